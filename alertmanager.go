@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	alertmanagerAlertName = "CronyJobFailed"
+	alertmanagerAlertsAPI = "/api/v2/alerts"
+)
+
+// AlertmanagerConfig configures alerts for failed jobs in Prometheus
+// Alertmanager. Alerts are resolved by the next successful run.
+type AlertmanagerConfig struct {
+	URL     string        `envconfig:"alertmanager_url"`
+	Headers string        `envconfig:"alertmanager_headers" secret:"true"`
+	Host    string        `envconfig:"alertmanager_host"`
+	Expiry  time.Duration `default:"24h"                    envconfig:"alertmanager_expiry"`
+	Retries int           `default:"3"                      envconfig:"alertmanager_retries"`
+	Timeout time.Duration `default:"30s"                    envconfig:"alertmanager_timeout"`
+
+	// firing is shared by all notifiers, so alerts are resolved even if the
+	// container was re-registered in between
+	firing *firingAlerts
+}
+
+func (c AlertmanagerConfig) String() string {
+	return fmt.Sprintf("alertmanager config [url=%s, host=%s, expiry=%s]", redactURL(c.URL), c.Host, c.Expiry)
+}
+
+func (c AlertmanagerConfig) notification(container CronyContainer) (*notification, error) {
+	if v, ok := container.Labels[alertmanagerURLLabel]; ok {
+		c.URL = v
+	}
+	if c.URL == "" {
+		return nil, nil
+	}
+
+	notifier, err := newAlertmanagerNotifier(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// alerts have to be resolved on success, so the notifier sees every result
+	return &notification{notifier: notifier, policy: Always, timeout: c.Timeout}, nil
+}
+
+// firingAlerts remembers the labels of the alert fired per job.
+type firingAlerts struct {
+	mu     sync.Mutex
+	labels map[string]map[string]string
+}
+
+func newFiringAlerts() *firingAlerts {
+	return &firingAlerts{labels: map[string]map[string]string{}}
+}
+
+func (f *firingAlerts) get(job string) (map[string]string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	labels, ok := f.labels[job]
+
+	return labels, ok
+}
+
+// set stores the labels of the job's alert, nil labels remove it.
+func (f *firingAlerts) set(job string, labels map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if labels == nil {
+		delete(f.labels, job)
+	} else {
+		f.labels[job] = labels
+	}
+}
+
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt,omitzero"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertmanagerNotifier fires an alert for failed runs and resolves it on the
+// next successful run.
+type alertmanagerNotifier struct {
+	config  AlertmanagerConfig
+	headers http.Header
+	client  *http.Client
+	now     func() time.Time
+}
+
+func newAlertmanagerNotifier(config AlertmanagerConfig) (*alertmanagerNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("alertmanager URL is empty")
+	}
+	if config.Expiry <= 0 {
+		return nil, errors.New("alertmanager expiry must be positive")
+	}
+
+	headers, err := parseHeaders(config.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Host == "" {
+		config.Host, _ = os.Hostname()
+	}
+	if config.firing == nil {
+		config.firing = newFiringAlerts()
+	}
+
+	return &alertmanagerNotifier{config: config, headers: headers, client: &http.Client{}, now: time.Now}, nil
+}
+
+func (n *alertmanagerNotifier) Name() string {
+	return "alertmanager"
+}
+
+// Notify fires or resolves the alert of the job. Runs of the same job don't
+// overlap, so the firing state is only locked while it is accessed, and a slow
+// Alertmanager doesn't delay the notifications of other jobs.
+func (n *alertmanagerNotifier) Notify(ctx context.Context, result RunResult) error {
+	now := n.now()
+	previous, wasFiring := n.config.firing.get(result.ContainerName)
+	if !wasFiring && result.FailedRuns > 0 {
+		// the alert was fired before crony was restarted
+		previous = n.labels(result.ContainerName, result.previous.ContainerID, result.previous.ReturnCode)
+		wasFiring = true
+	}
+
+	var alerts []alertmanagerAlert
+	if result.Success() {
+		if !wasFiring {
+			return nil
+		}
+		alerts = append(alerts, alertmanagerAlert{Labels: previous, EndsAt: now})
+	} else {
+		labels := n.labels(result.ContainerName, result.ContainerID, result.ReturnCode)
+		if wasFiring && !maps.Equal(previous, labels) {
+			alerts = append(alerts, alertmanagerAlert{Labels: previous, EndsAt: now})
+		}
+		alerts = append(alerts, alertmanagerAlert{
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     createTopic(result),
+				"description": pushMessage(result),
+			},
+			StartsAt: result.EndTime,
+			EndsAt:   now.Add(n.config.Expiry),
+		})
+	}
+
+	if err := n.post(ctx, alerts); err != nil {
+		return err
+	}
+
+	if result.Success() {
+		n.config.firing.set(result.ContainerName, nil)
+	} else {
+		n.config.firing.set(result.ContainerName, alerts[len(alerts)-1].Labels)
+	}
+
+	return nil
+}
+
+func (n *alertmanagerNotifier) labels(job, containerID string, returnCode int64) map[string]string {
+	return map[string]string{
+		"alertname": alertmanagerAlertName,
+		"job":       job,
+		"container": containerID,
+		"exit_code": strconv.FormatInt(returnCode, 10),
+		"host":      n.config.Host,
+	}
+}
+
+func (n *alertmanagerNotifier) post(ctx context.Context, alerts []alertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(n.config.URL, "/") + alertmanagerAlertsAPI
+
+	err = postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.headers {
+			req.Header[k] = v
+		}
+
+		return req, nil
+	})
+
+	return redactError(err, urlSecrets(url)...)
+}
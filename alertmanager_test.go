@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAlertmanagerNotifier(t *testing.T, url string, now time.Time) *alertmanagerNotifier {
+	t.Helper()
+	n, err := newAlertmanagerNotifier(AlertmanagerConfig{
+		URL: url, Headers: "Authorization: Basic abc", Host: "node1", Expiry: time.Hour,
+	})
+	require.NoError(t, err)
+	n.now = func() time.Time { return now }
+
+	return n
+}
+
+func receiveAlerts(t *testing.T, requests chan recordedWebhook) []alertmanagerAlert {
+	t.Helper()
+	req := <-requests
+	require.Equal(t, "Basic abc", req.header.Get("Authorization"))
+
+	var alerts []alertmanagerAlert
+	require.NoError(t, json.Unmarshal(req.body, &alerts))
+
+	return alerts
+}
+
+func TestAlertmanagerNotifier_FireAndResolve(t *testing.T) {
+	srv, requests, calls := webhookServer(t)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := newTestAlertmanagerNotifier(t, srv.URL, now)
+
+	// nothing to resolve
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+	require.Zero(t, calls.Load())
+
+	require.NoError(t, n.Notify(context.Background(),
+		RunResult{ContainerName: "backup", ContainerID: "abc", ReturnCode: 2, EndTime: now}))
+
+	alerts := receiveAlerts(t, requests)
+	require.Len(t, alerts, 1)
+	require.Equal(t, map[string]string{
+		"alertname": alertmanagerAlertName,
+		"job":       "backup",
+		"container": "abc",
+		"exit_code": "2",
+		"host":      "node1",
+	}, alerts[0].Labels)
+	require.Contains(t, alerts[0].Annotations["summary"], "backup")
+	require.True(t, alerts[0].StartsAt.Equal(now))
+	require.True(t, alerts[0].EndsAt.Equal(now.Add(time.Hour)))
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup", ContainerID: "abc"}))
+
+	alerts = receiveAlerts(t, requests)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "2", alerts[0].Labels["exit_code"])
+	require.True(t, alerts[0].EndsAt.Equal(now))
+
+	// resolved alerts are forgotten
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestAlertmanagerNotifier_ChangedExitCodeResolvesPreviousAlert(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+	now := time.Now()
+	n := newTestAlertmanagerNotifier(t, srv.URL, now)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup", ReturnCode: 1}))
+	receiveAlerts(t, requests)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup", ReturnCode: 3}))
+	alerts := receiveAlerts(t, requests)
+	require.Len(t, alerts, 2)
+	require.Equal(t, "1", alerts[0].Labels["exit_code"])
+	require.True(t, alerts[0].EndsAt.Equal(now))
+	require.Equal(t, "3", alerts[1].Labels["exit_code"])
+}
+
+func TestAlertmanagerNotifier_KeepsFiringStateOnError(t *testing.T) {
+	srv, requests, _ := webhookServer(t, http.StatusOK, http.StatusBadRequest)
+	n := newTestAlertmanagerNotifier(t, srv.URL, time.Now())
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup", ReturnCode: 1}))
+	receiveAlerts(t, requests)
+
+	require.Error(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+	receiveAlerts(t, requests)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+	alerts := receiveAlerts(t, requests)
+	require.Equal(t, "1", alerts[0].Labels["exit_code"], "alert is resolved by the next success")
+}
+
+func TestAlertmanagerNotifier_ResolvesAfterRestart(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+	now := time.Now()
+	n := newTestAlertmanagerNotifier(t, srv.URL, now)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{
+		ContainerName: "backup",
+		ContainerID:   "abc",
+		FailedRuns:    2,
+		previous:      jobState{FailedRuns: 2, ReturnCode: 3, ContainerID: "abc"},
+	}))
+
+	alerts := receiveAlerts(t, requests)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "3", alerts[0].Labels["exit_code"])
+	require.Equal(t, "abc", alerts[0].Labels["container"])
+	require.True(t, alerts[0].EndsAt.Equal(now))
+}
+
+func TestAlertmanagerNotifier_SlowAlertmanagerDoesNotBlockOtherJobs(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast, requests, _ := webhookServer(t)
+
+	firing := newFiringAlerts()
+	slowNotifier := newTestAlertmanagerNotifier(t, slow.URL, time.Now())
+	slowNotifier.config.firing = firing
+	fastNotifier := newTestAlertmanagerNotifier(t, fast.URL, time.Now())
+	fastNotifier.config.firing = firing
+
+	go func() {
+		_ = slowNotifier.Notify(context.Background(), RunResult{ContainerName: "slow", ReturnCode: 1})
+	}()
+
+	done := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		done <- fastNotifier.Notify(context.Background(), RunResult{ContainerName: "fast", ReturnCode: 1})
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+		receiveAlerts(t, requests)
+	case <-time.After(time.Second):
+		t.Fatal("notification blocked by the slow Alertmanager")
+	}
+}
+
+func TestAlertmanagerConfig_Notification(t *testing.T) {
+	global := AlertmanagerConfig{Expiry: time.Hour, Timeout: time.Second, firing: newFiringAlerts()}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no URL configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{alertmanagerURLLabel: "http://am:9093"}})
+	require.NoError(t, err)
+	require.Equal(t, Always, n.policy)
+	require.Equal(t, "alertmanager", n.notifier.Name())
+
+	other, err := global.notification(CronyContainer{Labels: map[string]string{alertmanagerURLLabel: "http://am:9093"}})
+	require.NoError(t, err)
+	require.Same(t, n.notifier.(*alertmanagerNotifier).config.firing,
+		other.notifier.(*alertmanagerNotifier).config.firing)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/0xERR0R/crony/internal/logarchive"
+	"github.com/0xERR0R/crony/internal/ringbuf"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// logDrainTimeout bounds the time to wait for the remaining output after
+	// the container has stopped.
+	logDrainTimeout = 10 * time.Second
+
+	combinedTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// outputCapture copies the output of a running job container into the
+// capture buffers (and the optional live log and archive) until the
+// container stops.
+type outputCapture struct {
+	logger   *log.Entry
+	stdout   *ringbuf.HeadTail
+	stderr   *ringbuf.HeadTail
+	combined *ringbuf.HeadTail
+	archive  *logarchive.Run
+	stream   io.ReadCloser
+	done     chan struct{}
+}
+
+func (cj *ContainerJob) captureOutput(logger *log.Entry, runID string, startTime time.Time) *outputCapture {
+	newBuffer := func() *ringbuf.HeadTail {
+		return ringbuf.NewHeadTail(cj.config.CaptureHeadSize, cj.config.CaptureTailSize, ringbuf.LineAware())
+	}
+	oc := &outputCapture{
+		logger:   logger,
+		stdout:   newBuffer(),
+		stderr:   newBuffer(),
+		combined: newBuffer(),
+		done:     make(chan struct{}),
+	}
+
+	stream, err := cj.docker.ContainerLogs(cj.containerName, startTime)
+	if err != nil {
+		logger.WithError(err).Error("can't retrieve logs")
+		msg := fmt.Sprintf("can't retrieve logs for container '%s'", cj.containerName)
+		_, _ = io.WriteString(oc.stdout, msg)
+		_, _ = io.WriteString(oc.combined, msg)
+		close(oc.done)
+
+		return oc
+	}
+	oc.stream = stream
+
+	var stdout, stderr, combined io.Writer = oc.stdout, oc.stderr, oc.combined
+	var liveOut, liveErr *lineLogger
+	if cj.config.LogOutput {
+		liveOut = newLineLogger(logger, "stdout")
+		liveErr = newLineLogger(logger, "stderr")
+		stdout = io.MultiWriter(stdout, liveOut)
+		stderr = io.MultiWriter(stderr, liveErr)
+	}
+	if cj.archive != nil {
+		run, err := cj.archive.Create(cj.containerName, runID)
+		if err != nil {
+			logger.WithError(err).Error("can't archive output")
+		} else {
+			oc.archive = run
+			stdout = io.MultiWriter(stdout, newArchiveWriter(logger, "stdout", run.Stdout()))
+			stderr = io.MultiWriter(stderr, newArchiveWriter(logger, "stderr", run.Stderr()))
+			combined = io.MultiWriter(combined, newArchiveWriter(logger, "combined", run.Combined()))
+		}
+	}
+
+	tsOut := newTimestampWriter("stdout", stdout, combined)
+	tsErr := newTimestampWriter("stderr", stderr, combined)
+
+	go func() {
+		defer close(oc.done)
+
+		if _, err := stdcopy.StdCopy(tsOut, tsErr, stream); err != nil {
+			logger.WithError(err).Error("can't retrieve output streams")
+		}
+		tsOut.Flush()
+		tsErr.Flush()
+		if liveOut != nil {
+			liveOut.Flush()
+			liveErr.Flush()
+		}
+	}()
+
+	return oc
+}
+
+// Wait waits until the complete output has been captured. It must be called
+// after the container has stopped.
+func (oc *outputCapture) Wait() {
+	if oc.stream == nil {
+		return
+	}
+
+	select {
+	case <-oc.done:
+	case <-time.After(logDrainTimeout):
+		oc.logger.Warn("timed out waiting for the end of the output stream")
+	}
+
+	_ = oc.stream.Close()
+	<-oc.done
+
+	if oc.archive != nil {
+		if err := oc.archive.Close(); err != nil {
+			oc.logger.WithError(err).Error("can't archive output")
+		}
+	}
+}
+
+// archiveWriter passes the output to the archive. io.MultiWriter stops at the
+// first error, so archive errors, e.g. a full disk, are logged once and
+// further output is dropped instead of aborting the capture.
+type archiveWriter struct {
+	logger *log.Entry
+	stream string
+	out    io.Writer
+	failed bool
+}
+
+func newArchiveWriter(logger *log.Entry, stream string, out io.Writer) *archiveWriter {
+	return &archiveWriter{logger: logger, stream: stream, out: out}
+}
+
+func (w *archiveWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+
+	if _, err := w.out.Write(p); err != nil {
+		w.failed = true
+		w.logger.WithError(err).WithField("stream", w.stream).Error("can't archive output, dropping the rest")
+	}
+
+	return len(p), nil
+}
+
+// timestampWriter processes one output stream as returned by Docker with
+// timestamps enabled: it strips the timestamp from each log message, passes
+// the lines to out and appends them, marked with time and stream name, to
+// combined. Both stream writers of a job share combined, which thereby holds
+// the chronologically interleaved output. Each Write has to be a single
+// message, as written by StdCopy; Docker splits lines longer than 16 KiB into
+// several messages, each with its own timestamp. It is not safe for
+// concurrent use.
+type timestampWriter struct {
+	stream   string
+	out      io.Writer
+	combined io.Writer
+	partial  []byte
+	lastTime time.Time
+}
+
+func newTimestampWriter(stream string, out, combined io.Writer) *timestampWriter {
+	return &timestampWriter{stream: stream, out: out, combined: combined}
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	ts, msg := splitTimestamp(p)
+	if len(w.partial) == 0 {
+		// the time of a line is the time of its first message
+		w.lastTime = ts
+	}
+	w.partial = append(w.partial, msg...)
+
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+
+	if len(w.partial) >= maxLogLineLength {
+		w.emit(w.partial)
+		w.partial = nil
+	}
+
+	return len(p), nil
+}
+
+// Flush processes a trailing line which was not terminated by a line break.
+func (w *timestampWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.emit(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *timestampWriter) emit(chunk []byte) {
+	_, _ = w.out.Write(chunk)
+
+	ts := "-"
+	if !w.lastTime.IsZero() {
+		ts = w.lastTime.Local().Format(combinedTimeFormat)
+	}
+	_, _ = fmt.Fprintf(w.combined, "%s %s | %s\n", ts, w.stream, bytes.TrimRight(chunk, "\r\n"))
+}
+
+// splitTimestamp splits the RFC 3339 timestamp Docker prepends to each line
+// from the line. Lines without a valid timestamp are returned unchanged.
+func splitTimestamp(line []byte) (time.Time, []byte) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return time.Time{}, line
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, string(line[:i]))
+	if err != nil {
+		return time.Time{}, line
+	}
+
+	return ts, line[i+1:]
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitTimestamp(t *testing.T) {
+	ts, line := splitTimestamp([]byte("2026-10-19T03:00:00.123456789Z hello world\n"))
+	require.Equal(t, time.Date(2026, 10, 19, 3, 0, 0, 123456789, time.UTC), ts)
+	require.Equal(t, "hello world\n", string(line))
+
+	ts, line = splitTimestamp([]byte("no timestamp here\n"))
+	require.True(t, ts.IsZero())
+	require.Equal(t, "no timestamp here\n", string(line))
+
+	ts, line = splitTimestamp([]byte("nospace"))
+	require.True(t, ts.IsZero())
+	require.Equal(t, "nospace", string(line))
+}
+
+func TestTimestampWriter_InterleavesStreams(t *testing.T) {
+	useUTC(t)
+	var stdout, stderr, combined bytes.Buffer
+	out := newTimestampWriter("stdout", &stdout, &combined)
+	errW := newTimestampWriter("stderr", &stderr, &combined)
+
+	_, _ = out.Write([]byte("2026-10-19T03:00:00.000000000Z starting\n"))
+	_, _ = out.Write([]byte("2026-10-19T03:00:01.5Z wor"))
+	_, _ = errW.Write([]byte("2026-10-19T03:00:02Z oops\n"))
+	_, _ = out.Write([]byte("2026-10-19T03:00:02.5Z king\n"))
+	_, _ = out.Write([]byte("2026-10-19T03:00:03Z no newline"))
+	out.Flush()
+	errW.Flush()
+
+	require.Equal(t, "starting\nworking\nno newline", stdout.String())
+	require.Equal(t, "oops\n", stderr.String())
+	require.Equal(t, strings.Join([]string{
+		"2026-10-19T03:00:00.000Z stdout | starting",
+		"2026-10-19T03:00:02.000Z stderr | oops",
+		"2026-10-19T03:00:01.500Z stdout | working",
+		"2026-10-19T03:00:03.000Z stdout | no newline",
+		"",
+	}, "\n"), combined.String())
+}
+
+func TestTimestampWriter_OverlongLine(t *testing.T) {
+	useUTC(t)
+	var stdout, combined bytes.Buffer
+	w := newTimestampWriter("stdout", &stdout, &combined)
+
+	long := strings.Repeat("x", maxLogLineLength)
+	_, _ = w.Write([]byte("2026-10-19T03:00:00Z " + long))
+	_, _ = w.Write([]byte("2026-10-19T03:00:00Z tail\n"))
+	w.Flush()
+
+	// the continuation of the overlong line is a message with its own timestamp
+	require.Equal(t, long+"tail\n", stdout.String())
+	lines := strings.Split(strings.TrimSpace(combined.String()), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[1], "2026-10-19T03:00:00.000Z stdout | "))
+}
+
+func useUTC(t *testing.T) {
+	t.Helper()
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(_ []byte) (int, error) {
+	w.writes++
+
+	return 0, errors.New("no space left on device")
+}
+
+func TestArchiveWriter_DropsErrors(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	archive := &failingWriter{}
+	var captured bytes.Buffer
+	w := io.MultiWriter(&captured, newArchiveWriter(log.NewEntry(logger), "stdout", archive))
+
+	for range 3 {
+		_, err := io.WriteString(w, "line\n")
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, "line\nline\nline\n", captured.String())
+	require.Equal(t, 1, archive.writes, "no writes after the first error")
+	require.Len(t, hook.Entries, 1)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxChatStderrLength caps the stderr tail, chat servers reject long messages.
+const maxChatStderrLength = 2000
+
+// ChatConfig configures notifications to Slack compatible incoming webhooks,
+// e.g. Slack or Mattermost.
+type ChatConfig struct {
+	WebhookURL  string        `envconfig:"chat_webhook_url" secret:"true"`
+	Channel     string        `envconfig:"chat_channel"`
+	Username    string        `default:"crony"              envconfig:"chat_username"`
+	StderrLines int           `default:"10"                 envconfig:"chat_stderr_lines"`
+	Retries     int           `default:"3"                  envconfig:"chat_retries"`
+	Policy      MailPolicy    `default:"never"              envconfig:"chat_policy"`
+	Timeout     time.Duration `default:"30s"                envconfig:"chat_timeout"`
+}
+
+func (c ChatConfig) String() string {
+	return fmt.Sprintf("chat config [channel=%s, username=%s, policy=%s]", c.Channel, c.Username, c.Policy)
+}
+
+// forContainer applies the container's label overrides.
+func (c ChatConfig) forContainer(container CronyContainer) (ChatConfig, error) {
+	if v, ok := container.Labels[chatWebhookURLLabel]; ok {
+		c.WebhookURL = v
+	}
+	if v, ok := container.Labels[chatChannelLabel]; ok {
+		c.Channel = v
+	}
+	if v, ok := container.Labels[chatPolicyLabel]; ok {
+		if err := c.Policy.Decode(v); err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", chatPolicyLabel, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c ChatConfig) notification(container CronyContainer) (*notification, error) {
+	cfg, err := c.forContainer(container)
+	if err != nil || cfg.WebhookURL == "" {
+		return nil, err
+	}
+
+	notifier, err := newChatNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification{notifier: notifier, policy: cfg.Policy, timeout: cfg.Timeout}, nil
+}
+
+type chatMessage struct {
+	Channel     string           `json:"channel,omitempty"`
+	Username    string           `json:"username,omitempty"`
+	Text        string           `json:"text"`
+	Attachments []chatAttachment `json:"attachments,omitempty"`
+}
+
+type chatAttachment struct {
+	Color    string `json:"color"`
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// chatNotifier posts the result of a job run to a Slack compatible incoming
+// webhook.
+type chatNotifier struct {
+	config ChatConfig
+	client *http.Client
+}
+
+func newChatNotifier(config ChatConfig) (*chatNotifier, error) {
+	if config.WebhookURL == "" {
+		return nil, errors.New("chat webhook URL is empty")
+	}
+
+	return &chatNotifier{config: config, client: &http.Client{}}, nil
+}
+
+func (n *chatNotifier) Name() string {
+	return "chat"
+}
+
+func (n *chatNotifier) Notify(ctx context.Context, result RunResult) error {
+	body, err := json.Marshal(n.message(result))
+	if err != nil {
+		return err
+	}
+
+	err = postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.config.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		return req, nil
+	})
+
+	// the webhook URL contains the credentials
+	return redactError(err, n.config.WebhookURL)
+}
+
+func (n *chatNotifier) message(result RunResult) chatMessage {
+	msg := chatMessage{
+		Channel:  n.config.Channel,
+		Username: n.config.Username,
+	}
+
+	switch {
+	case result.Recovered():
+		msg.Text = fmt.Sprintf(":white_check_mark: *%s* recovered after %d failed runs, return code %d in %s",
+			result.ContainerName, result.FailedRuns, result.ReturnCode, result.ShortDuration())
+	case result.Success():
+		msg.Text = fmt.Sprintf(":white_check_mark: *%s* succeeded, return code %d in %s",
+			result.ContainerName, result.ReturnCode, result.ShortDuration())
+	default:
+		msg.Text = fmt.Sprintf(":x: *%s* failed, return code %d in %s",
+			result.ContainerName, result.ReturnCode, result.ShortDuration())
+		if result.OutputFailure != "" {
+			msg.Text += ": " + result.OutputFailure
+		}
+	}
+
+	if stderr := tailLines(result.StdErr, n.config.StderrLines, maxChatStderrLength); stderr != "" {
+		color := "good"
+		if !result.Success() {
+			color = "danger"
+		}
+		// Slack and Mattermost collapse long attachments behind "Show more"
+		msg.Attachments = []chatAttachment{{
+			Color:    color,
+			Title:    "stderr",
+			Text:     "```\n" + stderr + "\n```",
+			Fallback: msg.Text,
+		}}
+	}
+
+	return msg
+}
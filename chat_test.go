@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatNotifier_Failure(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newChatNotifier(ChatConfig{WebhookURL: srv.URL, Channel: "#ops", Username: "crony", StderrLines: 2})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), RunResult{
+		ContainerName: "backup",
+		ReturnCode:    3,
+		Duration:      90 * time.Second,
+		StdErr:        "one\ntwo\nthree\n",
+	})
+	require.NoError(t, err)
+
+	req := <-requests
+	require.Equal(t, http.MethodPost, req.method)
+	require.Equal(t, "application/json", req.header.Get("Content-Type"))
+
+	var msg chatMessage
+	require.NoError(t, json.Unmarshal(req.body, &msg))
+	require.Equal(t, "#ops", msg.Channel)
+	require.Equal(t, "crony", msg.Username)
+	require.Equal(t, ":x: *backup* failed, return code 3 in 1 minute 30 seconds", msg.Text)
+	require.Len(t, msg.Attachments, 1)
+	require.Equal(t, "danger", msg.Attachments[0].Color)
+	require.Equal(t, "```\ntwo\nthree\n```", msg.Attachments[0].Text)
+}
+
+func TestChatNotifier_Message(t *testing.T) {
+	n, err := newChatNotifier(ChatConfig{WebhookURL: "http://localhost", StderrLines: 10})
+	require.NoError(t, err)
+
+	msg := n.message(RunResult{ContainerName: "backup", Duration: time.Second})
+	require.Equal(t, ":white_check_mark: *backup* succeeded, return code 0 in 1 second", msg.Text)
+	require.Empty(t, msg.Attachments, "no stderr")
+
+	msg = n.message(RunResult{ContainerName: "backup", FailedRuns: 2, Duration: time.Second})
+	require.Equal(t, ":white_check_mark: *backup* recovered after 2 failed runs, return code 0 in 1 second", msg.Text)
+
+	msg = n.message(RunResult{ContainerName: "backup", OutputFailure: "output matched: ERROR", StdErr: "warn"})
+	require.Equal(t, ":x: *backup* failed, return code 0 in 0 seconds: output matched: ERROR", msg.Text)
+	require.Equal(t, "danger", msg.Attachments[0].Color)
+}
+
+func TestChatConfig_Notification(t *testing.T) {
+	global := ChatConfig{Channel: "#ops", Policy: OnError, Timeout: time.Second}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no webhook URL configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{
+		chatWebhookURLLabel: "http://example.com/hook",
+		chatChannelLabel:    "#backups",
+		chatPolicyLabel:     "always",
+	}})
+	require.NoError(t, err)
+	require.NotNil(t, n)
+	require.Equal(t, Always, n.policy)
+	require.Equal(t, "chat", n.notifier.Name())
+	notifier, ok := n.notifier.(*chatNotifier)
+	require.True(t, ok)
+	require.Equal(t, "#backups", notifier.config.Channel)
+
+	_, err = global.notification(CronyContainer{Labels: map[string]string{
+		chatWebhookURLLabel: "http://example.com/hook",
+		chatPolicyLabel:     "sometimes",
+	}})
+	require.ErrorContains(t, err, chatPolicyLabel)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/0xERR0R/crony/healthchecks"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config holds the global, job-related settings. Notification settings are
+// kept separately in MailConfig.
+type Config struct {
+	LogJobOutput       bool          `default:"false"   envconfig:"log_job_output"`
+	LogArchiveDir      string        `envconfig:"log_archive_dir"`
+	LogArchiveMaxAge   time.Duration `default:"2160h"   envconfig:"log_archive_max_age"`
+	LogArchiveMaxSize  int64         `default:"0"       envconfig:"log_archive_max_size"`
+	LogArchiveAPIToken string        `envconfig:"log_archive_api_token" secret:"true"`
+	CaptureHeadSize    int           `default:"65536"   envconfig:"capture_head_size"`
+	CaptureTailSize    int           `default:"1048576" envconfig:"capture_tail_size"`
+	StateFile          string        `envconfig:"state_file"`
+
+	HcBaseURL string        `envconfig:"hc_base_url"`
+	HcTimeout time.Duration `default:"10s" envconfig:"hc_timeout"`
+	HcProxy   string        `envconfig:"hc_proxy"`
+	HcCAFile  string        `envconfig:"hc_ca_file"`
+}
+
+func loadConfig() (*Config, error) {
+	var cfg Config
+	if err := processConfig(&cfg); err != nil {
+		return nil, fmt.Errorf("can't parse config: %w", err)
+	}
+
+	if cfg.CaptureHeadSize < 0 {
+		return nil, errors.New("CAPTURE_HEAD_SIZE must not be negative")
+	}
+	if cfg.CaptureTailSize <= 0 {
+		return nil, errors.New("CAPTURE_TAIL_SIZE must be positive")
+	}
+	if cfg.HcTimeout < 0 {
+		return nil, errors.New("HC_TIMEOUT must not be negative")
+	}
+
+	return &cfg, nil
+}
+
+// healthchecksOptions returns the options of the healthchecks.io checks of
+// all jobs. The checks share one HTTP client, so connections are reused.
+func (c *Config) healthchecksOptions() ([]healthchecks.Option, error) {
+	var opts []healthchecks.Option
+
+	if c.HcProxy != "" {
+		proxy, err := url.Parse(c.HcProxy)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse HC_PROXY: %w", err)
+		}
+		opts = append(opts, healthchecks.WithProxy(proxy))
+	}
+
+	if c.HcCAFile != "" {
+		pem, err := os.ReadFile(c.HcCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read HC_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in HC_CA_FILE '%s'", c.HcCAFile)
+		}
+		opts = append(opts, healthchecks.WithRootCAs(pool))
+	}
+
+	return []healthchecks.Option{
+		healthchecks.WithTimeout(c.HcTimeout),
+		healthchecks.WithHTTPClient(healthchecks.NewClient(opts...)),
+	}, nil
+}
+
+// JobConfig holds the settings of a single job: the global Config with the
+// container's label overrides applied.
+type JobConfig struct {
+	LogOutput       bool
+	CaptureHeadSize int
+	CaptureTailSize int
+}
+
+func (c *Config) forContainer(container CronyContainer) JobConfig {
+	logger := log.WithFields(log.Fields{
+		"job":          container.Name,
+		"container_id": container.ID,
+	})
+
+	jc := JobConfig{
+		LogOutput:       c.LogJobOutput,
+		CaptureHeadSize: c.CaptureHeadSize,
+		CaptureTailSize: c.CaptureTailSize,
+	}
+
+	if container.LogOutput != "" {
+		v, err := strconv.ParseBool(container.LogOutput)
+		if err != nil {
+			logger.WithError(err).Errorf("can't parse '%s' label", logOutputLabel)
+		} else {
+			jc.LogOutput = v
+		}
+	}
+
+	if container.CaptureHeadSize != "" {
+		v, err := strconv.Atoi(container.CaptureHeadSize)
+		if err != nil || v < 0 {
+			logger.Errorf("can't parse '%s' label, expected a non-negative number of bytes", captureHeadSizeLabel)
+		} else {
+			jc.CaptureHeadSize = v
+		}
+	}
+
+	if container.CaptureTailSize != "" {
+		v, err := strconv.Atoi(container.CaptureTailSize)
+		if err != nil || v <= 0 {
+			logger.Errorf("can't parse '%s' label, expected a positive number of bytes", captureTailSizeLabel)
+		} else {
+			jc.CaptureTailSize = v
+		}
+	}
+
+	return jc
+}
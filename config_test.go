@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := loadConfig()
+	require.NoError(t, err)
+	require.False(t, cfg.LogJobOutput)
+}
+
+func TestLoadConfig_InvalidValue(t *testing.T) {
+	t.Setenv("LOG_JOB_OUTPUT", "maybe")
+	_, err := loadConfig()
+	require.Error(t, err)
+}
+
+func TestConfig_ForContainer_LogOutput(t *testing.T) {
+	cfg := &Config{LogJobOutput: false}
+
+	require.False(t, cfg.forContainer(CronyContainer{}).LogOutput)
+	require.True(t, cfg.forContainer(CronyContainer{LogOutput: "true"}).LogOutput)
+
+	cfg.LogJobOutput = true
+	require.False(t, cfg.forContainer(CronyContainer{LogOutput: "false"}).LogOutput)
+	require.True(t, cfg.forContainer(CronyContainer{LogOutput: "garbage"}).LogOutput)
+}
+
+func TestLoadConfig_CaptureSizes(t *testing.T) {
+	cfg, err := loadConfig()
+	require.NoError(t, err)
+	require.Equal(t, 64*1024, cfg.CaptureHeadSize)
+	require.Equal(t, 1024*1024, cfg.CaptureTailSize)
+
+	t.Setenv("CAPTURE_TAIL_SIZE", "0")
+	_, err = loadConfig()
+	require.ErrorContains(t, err, "CAPTURE_TAIL_SIZE")
+
+	t.Setenv("CAPTURE_TAIL_SIZE", "10")
+	t.Setenv("CAPTURE_HEAD_SIZE", "-1")
+	_, err = loadConfig()
+	require.ErrorContains(t, err, "CAPTURE_HEAD_SIZE")
+}
+
+func TestConfig_ForContainer_CaptureSizes(t *testing.T) {
+	cfg := &Config{CaptureHeadSize: 100, CaptureTailSize: 200}
+
+	jc := cfg.forContainer(CronyContainer{})
+	require.Equal(t, 100, jc.CaptureHeadSize)
+	require.Equal(t, 200, jc.CaptureTailSize)
+
+	jc = cfg.forContainer(CronyContainer{CaptureHeadSize: "0", CaptureTailSize: "4096"})
+	require.Equal(t, 0, jc.CaptureHeadSize)
+	require.Equal(t, 4096, jc.CaptureTailSize)
+
+	jc = cfg.forContainer(CronyContainer{CaptureHeadSize: "-5", CaptureTailSize: "0"})
+	require.Equal(t, 100, jc.CaptureHeadSize)
+	require.Equal(t, 200, jc.CaptureTailSize)
+}
+
+func TestConfig_HealthchecksOptions(t *testing.T) {
+	opts, err := (&Config{HcTimeout: time.Second}).healthchecksOptions()
+	require.NoError(t, err)
+	require.Len(t, opts, 2, "timeout and shared client")
+
+	_, caPEM := selfSignedCert(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+	opts, err = (&Config{HcProxy: "http://proxy:3128", HcCAFile: caFile}).healthchecksOptions()
+	require.NoError(t, err)
+	require.Len(t, opts, 2, "proxy and CAs are part of the shared client")
+
+	_, err = (&Config{HcProxy: "http://[::1"}).healthchecksOptions()
+	require.ErrorContains(t, err, "HC_PROXY")
+
+	_, err = (&Config{HcCAFile: caFile + ".missing"}).healthchecksOptions()
+	require.ErrorContains(t, err, "HC_CA_FILE")
+
+	require.NoError(t, os.WriteFile(caFile, []byte("no certificate"), 0o600))
+	_, err = (&Config{HcCAFile: caFile}).healthchecksOptions()
+	require.ErrorContains(t, err, "no certificates")
+}
+
+func TestLoadConfig_HcTimeout(t *testing.T) {
+	t.Setenv("HC_TIMEOUT", "-1s")
+	_, err := loadConfig()
+	require.ErrorContains(t, err, "HC_TIMEOUT")
+}
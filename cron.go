@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"io"
+	"os"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xERR0R/crony/healthchecks"
-	"github.com/0xERR0R/crony/internal/ringbuf"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/0xERR0R/crony/internal/logarchive"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/robfig/cron/v3"
@@ -17,7 +19,7 @@ import (
 )
 
 const (
-	maxLogSize = 1 * 1024 * 1024
+	runIDLength = 8
 )
 
 //nolint:gochecknoglobals // prometheus metrics are conventionally package-level
@@ -40,32 +42,59 @@ var (
 
 type ContainerJob struct {
 	docker        *DockerClient
+	containerID   string
 	containerName string
-	mailConfig    *MailConfig
+	schedule      string
+	labels        map[string]string
+	attempts      atomic.Int64
+	config        JobConfig
+	// notifications is replaced when the secrets are reloaded
+	notifications atomic.Pointer[[]notification]
 	hc            *healthchecks.Check
+	outputMatcher *OutputMatcher
+	archive       *logarchive.Archive
+	states        *jobStates
+	// ctx is the root context, cancelled on shutdown to cancel the pings
+	ctx context.Context //nolint:containedctx // cron.Job.Run has no context parameter
 }
 
-//nolint:funlen // job run orchestrates start/wait/logs/mail; splitting hurts readability
+//nolint:funlen // job run orchestrates start/wait/logs/notifications; splitting hurts readability
 func (cj *ContainerJob) Run() {
-	log.Debugf("starting execution of container '%s'", cj.containerName)
+	runID := newRunID()
+	attempt := cj.attempts.Add(1)
+	logger := log.WithFields(log.Fields{
+		"job":          cj.containerName,
+		"container_id": cj.containerID,
+		"run_id":       runID,
+	})
+	logger.Debug("starting execution")
+
+	ctx := cj.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	startTime := time.Now()
 
 	err := cj.docker.ContainerStart(cj.containerName)
 	if err != nil {
-		log.Errorf("can't start container '%s': %v", cj.containerName, err)
+		logger.WithError(err).Error("can't start container")
 
 		return
 	}
 
-	cj.jobStarted()
+	cj.jobStarted(ctx, logger)
+
+	output := cj.captureOutput(logger, runID, startTime)
 
 	statusCh, errCh := cj.docker.ContainerWait(cj.containerName)
 	var returnCode int64
 	select {
 	case err := <-errCh:
 		if err != nil {
-			log.Errorf("can't wait for the end of the execution of container '%s': %v", cj.containerName, err)
+			logger.WithError(err).Error("can't wait for the end of the execution")
+			// close the log stream and finalize the archive
+			output.Wait()
 
 			return
 		}
@@ -73,73 +102,138 @@ func (cj *ContainerJob) Run() {
 		returnCode = s.StatusCode
 	}
 
-	labels := prometheus.Labels{
-		"container_name": cj.containerName,
-		"success":        strconv.FormatBool(returnCode == 0),
-	}
-	defer executed.With(labels).Inc()
-
-	defer lastExecutionGauge.With(labels).Set(float64(startTime.Unix()))
 	endTime := time.Now()
 	jobDuration := endTime.Sub(startTime)
 
-	defer durationGauge.With(labels).Set(jobDuration.Seconds())
-
-	log.StandardLogger().Logf(logLevelForReturnCode(returnCode),
-		"Execution of container '%s' finished with return code %d", cj.containerName, returnCode)
+	output.Wait()
+
+	result := RunResult{
+		ContainerName: cj.containerName,
+		ContainerID:   cj.containerID,
+		RunID:         runID,
+		Hostname:      hostname(),
+		Schedule:      cj.schedule,
+		Attempt:       int(attempt),
+		ReturnCode:    returnCode,
+		StartTime:     startTime,
+		EndTime:       endTime,
+		Duration:      jobDuration,
+		StdOut:        output.stdout.String(),
+		StdErr:        output.stderr.String(),
+		Output:        output.combined.String(),
+		NextRun:       nextRun(cj.schedule, endTime),
+		Labels:        cj.labels,
+	}
+	if returnCode == 0 {
+		result.OutputFailure = cj.outputMatcher.Check(result.StdOut, result.StdErr)
+	} else {
+		result.OutputSuccess = cj.outputMatcher.Override(result.StdOut, result.StdErr)
+	}
+	cj.recordState(logger, &result)
 
-	out, err := cj.docker.ContainerLogs(cj.containerName, startTime)
-	if err != nil {
-		log.Errorf("can't retrieve logs for container '%s': %v", cj.containerName, err)
-		out = io.NopCloser(strings.NewReader(fmt.Sprintf("can't retrieve logs for container '%s'", cj.containerName)))
+	labels := prometheus.Labels{
+		"container_name": cj.containerName,
+		"success":        strconv.FormatBool(result.Success()),
+	}
+	executed.With(labels).Inc()
+	lastExecutionGauge.With(labels).Set(float64(startTime.Unix()))
+	durationGauge.With(labels).Set(jobDuration.Seconds())
+
+	logger = logger.WithFields(log.Fields{
+		"exit_code":   returnCode,
+		"duration_ms": jobDuration.Milliseconds(),
+		"outcome":     result.Outcome(),
+	})
+	switch {
+	case result.OutputFailure != "":
+		logger.WithField("reason", result.OutputFailure).Warn("execution finished, output indicates a failure")
+	case result.OutputSuccess != "":
+		logger.WithField("reason", result.OutputSuccess).Info("execution finished, output indicates a success")
+	default:
+		logger.Log(logLevelForReturnCode(returnCode), "execution finished")
 	}
 
-	log.Debug("using mail config: ", cj.mailConfig)
+	cj.jobFinished(ctx, logger, result)
 
-	stdOutBuf := ringbuf.New(maxLogSize)
-	stdErrBuf := ringbuf.New(maxLogSize)
-	_, err = stdcopy.StdCopy(stdOutBuf, stdErrBuf, out)
-	if err != nil {
-		log.Error("can't retrieve output streams: ", err)
-	}
+	notifyAll(logger, cj.notifiers(), result)
+}
 
-	stdout := stdOutBuf.String()
-	stderr := stdErrBuf.String()
+func (cj *ContainerJob) setNotifications(notifications []notification) {
+	cj.notifications.Store(&notifications)
+}
 
-	cj.jobFinished(returnCode, fmt.Sprintf("%s\n%s", stdout, stderr))
+func (cj *ContainerJob) notifiers() []notification {
+	if n := cj.notifications.Load(); n != nil {
+		return *n
+	}
 
-	if cj.mailConfig.MailPolicy == Always || (cj.mailConfig.MailPolicy == OnError && returnCode != 0) {
-		err = SendMail(cj.mailConfig, MailParams{
-			ContainerName: cj.containerName,
-			ReturnCode:    returnCode,
-			Duration:      jobDuration,
-			StdOut:        stdout,
-			StdErr:        stderr,
-		})
+	return nil
+}
+
+func (cj *ContainerJob) jobFinished(ctx context.Context, logger *log.Entry, result RunResult) {
+	if cj.hc != nil {
+		var err error
+		switch {
+		case result.OutputFailure != "":
+			err = cj.hc.Fail(ctx, fmt.Sprintf("%s\n\n%s", result.OutputFailure, result.Output))
+		case result.OutputSuccess != "":
+			err = cj.hc.Ping(ctx, 0, fmt.Sprintf("%s\n\n%s", result.OutputSuccess, result.Output))
+		default:
+			err = cj.hc.Ping(ctx, result.ReturnCode, result.Output)
+		}
 		if err != nil {
-			log.Error("can't send mail: ", err)
+			logger.WithError(err).Error("can't ping 'end' to hc.io")
 		}
 	}
 }
 
-func (cj *ContainerJob) jobFinished(returnCode int64, message string) {
+func (cj *ContainerJob) jobStarted(ctx context.Context, logger *log.Entry) {
 	if cj.hc != nil {
-		err := cj.hc.Ping(returnCode, message)
+		err := cj.hc.Start(ctx)
 		if err != nil {
-			log.Error("can't ping 'end' to hc.io: ", err)
+			logger.WithError(err).Error("can't ping 'start' to hc.io")
 		}
 	}
 }
 
-func (cj *ContainerJob) jobStarted() {
-	if cj.hc != nil {
-		err := cj.hc.Start()
+func (cj *ContainerJob) recordState(logger *log.Entry, result *RunResult) {
+	if cj.states != nil {
+		previous, err := cj.states.record(cj.containerName, *result)
 		if err != nil {
-			log.Error("can't ping 'start' to hc.io: ", err)
+			logger.WithError(err).Error("can't save job state")
 		}
+		result.StateChanged = previous.Success != result.Success()
+		result.FailedRuns = previous.FailedRuns
+		result.previous = previous
 	}
 }
 
+// nextRun returns the next time the schedule is due after t, or the zero
+// time if it can't be parsed.
+func nextRun(schedule string, t time.Time) time.Time {
+	s, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return s.Next(t)
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+
+	return name
+}
+
+// newRunID returns a random identifier used to correlate all log lines of a
+// single job execution.
+func newRunID() string {
+	b := make([]byte, runIDLength)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
 func logLevelForReturnCode(returnCode int64) log.Level {
 	if returnCode != 0 {
 		return log.WarnLevel
@@ -153,11 +247,15 @@ type SkipLogger struct {
 }
 
 func (l *SkipLogger) Info(_ string, _ ...interface{}) {
-	log.StandardLogger().Infof("skipping execution of container '%s', is still running", l.containerName)
+	log.WithField("job", l.containerName).Info("skipping execution, container is still running")
 }
 
 func (l *SkipLogger) Error(err error, msg string, keysAndValues ...interface{}) {
-	log.StandardLogger().Error(err, msg, keysAndValues)
+	fields := log.Fields{"job": l.containerName}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	log.WithFields(fields).WithError(err).Error(msg)
 }
 
 func createAndStartCron() *cron.Cron {
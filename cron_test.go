@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -24,7 +26,59 @@ func TestSkipLogger_Info(t *testing.T) {
 
 	entries := hook.AllEntries()
 	require.Len(t, entries, 1)
-	require.Contains(t, entries[0].Message, "my-job")
+	require.Equal(t, "my-job", entries[0].Data["job"])
 	require.Contains(t, entries[0].Message, "skipping execution")
 	require.Equal(t, logrus.InfoLevel, entries[0].Level)
 }
+
+func TestSkipLogger_Error(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	skipper := &SkipLogger{containerName: "my-job"}
+	skipper.Error(errors.New("boom"), "job panicked", "attempt", 2)
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 1)
+	require.Equal(t, "job panicked", entries[0].Message)
+	require.Equal(t, "my-job", entries[0].Data["job"])
+	require.Equal(t, 2, entries[0].Data["attempt"])
+	require.EqualError(t, entries[0].Data[logrus.ErrorKey].(error), "boom")
+}
+
+func TestNewRunID(t *testing.T) {
+	id := newRunID()
+	require.Len(t, id, 2*runIDLength)
+	require.NotEqual(t, id, newRunID())
+}
+
+func TestContainerJob_RecordState(t *testing.T) {
+	states, err := loadJobStates("")
+	require.NoError(t, err)
+	cj := &ContainerJob{containerName: "job", states: states}
+	logger := logrus.NewEntry(logrus.StandardLogger())
+
+	failure := RunResult{ReturnCode: 1}
+	cj.recordState(logger, &failure)
+	require.True(t, failure.StateChanged)
+	require.Zero(t, failure.FailedRuns)
+
+	failure = RunResult{ReturnCode: 1}
+	cj.recordState(logger, &failure)
+	require.False(t, failure.StateChanged)
+	require.Equal(t, 1, failure.FailedRuns)
+
+	success := RunResult{}
+	cj.recordState(logger, &success)
+	require.True(t, success.StateChanged)
+	require.True(t, success.Recovered())
+	require.Equal(t, 2, success.FailedRuns)
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+
+	require.Equal(t, time.Date(2026, 1, 2, 3, 5, 0, 0, time.Local), nextRun("*/5 * * * *", now))
+	require.Equal(t, time.Date(2026, 1, 3, 0, 0, 0, 0, time.Local), nextRun("@daily", now))
+	require.True(t, nextRun("invalid", now).IsZero())
+}
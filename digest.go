@@ -0,0 +1,149 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// digest collects run results and sends them as summary at a fixed interval.
+// The results are grouped by the sender and recipients of the jobs, so jobs
+// with their own recipients, e.g. from the crony.mail_to label, get a summary
+// of their own.
+type digest struct {
+	interval time.Duration
+	send     func(config *MailConfig, results []RunResult) error
+
+	mu      sync.Mutex
+	results []digestEntry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// digestEntry is a collected result with the mail config of its job.
+type digestEntry struct {
+	config *MailConfig
+	result RunResult
+}
+
+func newDigest(interval time.Duration, send func(config *MailConfig, results []RunResult) error) *digest {
+	return &digest{
+		interval: interval,
+		send:     send,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// add collects the result. The digest only shows metadata, so the output is
+// dropped to bound the memory used by long intervals.
+func (d *digest) add(config *MailConfig, result RunResult) {
+	result.StdOut, result.StdErr, result.Output = "", "", ""
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.results = append(d.results, digestEntry{config: config, result: result})
+}
+
+// start sends the collected results every interval until Stop is called.
+func (d *digest) start() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.flush()
+			case <-d.stop:
+				d.flush()
+
+				return
+			}
+		}
+	}()
+}
+
+// Stop sends the pending results and stops the digest.
+func (d *digest) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *digest) flush() {
+	d.mu.Lock()
+	results := d.results
+	d.results = nil
+	d.mu.Unlock()
+
+	type group struct {
+		config  *MailConfig
+		results []RunResult
+	}
+	var groups []*group
+	byRecipients := map[string]*group{}
+	for _, e := range results {
+		key := strings.Join([]string{e.config.MailFrom, e.config.MailTo, e.config.MailCc, e.config.MailBcc}, "\x00")
+		g, ok := byRecipients[key]
+		if !ok {
+			g = &group{config: e.config}
+			byRecipients[key] = g
+			groups = append(groups, g)
+		}
+		g.results = append(g.results, e.result)
+	}
+
+	for _, g := range groups {
+		logger := log.WithFields(log.Fields{"runs": len(g.results), "to": g.config.MailTo})
+		if err := d.send(g.config, g.results); err != nil {
+			logger.WithError(err).Error("can't send digest")
+
+			continue
+		}
+		logger.Debug("digest sent")
+	}
+}
+
+// DigestParams is the data model exposed to the digest mail template.
+type DigestParams struct {
+	Results []RunResult
+	Failed  int
+}
+
+func newDigestParams(results []RunResult) DigestParams {
+	params := DigestParams{Results: results}
+	for _, r := range results {
+		if !r.Success() {
+			params.Failed++
+		}
+	}
+
+	return params
+}
+
+func newDigestTemplate() *template.Template {
+	//nolint:staticcheck // ST1018: unicode glyphs in template body are intentional
+	return template.Must(template.New("digest-body").Parse(`
+		<p>📋 <b>{{len .Results}}</b> job runs, <b>{{.Failed}}</b> failed</p>
+		<table border="1" cellpadding="4" style="border-collapse: collapse">
+			<tr><th>Container</th><th>Started</th><th>Outcome</th><th>Return code</th><th>Duration</th><th>Reason</th></tr>
+			{{- range .Results}}
+			<tr>
+				<td>{{.ContainerName}}</td>
+				<td>{{.StartTime.Format "2006-01-02 15:04:05"}}</td>
+				<td>{{if .Success}}✔️ success{{else}}❌ failure{{end}}</td>
+				<td>{{.ReturnCode}}</td>
+				<td>{{.ShortDuration}}</td>
+				<td>{{.OutputFailure}}</td>
+			</tr>
+			{{- end}}
+		</table>
+  `))
+}
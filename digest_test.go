@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type digestRecorder struct {
+	mu      sync.Mutex
+	sends   [][]RunResult
+	configs []*MailConfig
+}
+
+func (r *digestRecorder) send(config *MailConfig, results []RunResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sends = append(r.sends, results)
+	r.configs = append(r.configs, config)
+
+	return nil
+}
+
+func (r *digestRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.sends)
+}
+
+func TestDigest_SendsCollectedResults(t *testing.T) {
+	rec := &digestRecorder{}
+	d := newDigest(20*time.Millisecond, rec.send)
+	d.start()
+
+	cfg := &MailConfig{MailTo: "ops@example.com"}
+	d.add(cfg, RunResult{ContainerName: "a"})
+	d.add(cfg, RunResult{ContainerName: "b"})
+
+	require.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, rec.count(), "empty digests are not sent")
+
+	d.add(cfg, RunResult{ContainerName: "c"})
+	d.Stop()
+
+	require.Equal(t, 2, rec.count(), "pending results are sent on stop")
+	require.Len(t, rec.sends[0], 2)
+	require.Equal(t, "c", rec.sends[1][0].ContainerName)
+}
+
+func TestDigest_SendErrorDropsResults(t *testing.T) {
+	calls := 0
+	d := newDigest(time.Hour, func(_ *MailConfig, _ []RunResult) error {
+		calls++
+
+		return errors.New("smtp down")
+	})
+
+	d.add(&MailConfig{}, RunResult{})
+	d.flush()
+	d.flush()
+	require.Equal(t, 1, calls)
+}
+
+func TestDigest_DropsOutput(t *testing.T) {
+	d := newDigest(time.Hour, (&digestRecorder{}).send)
+
+	cfg := &MailConfig{}
+	d.add(cfg, RunResult{ContainerName: "a", ReturnCode: 1, StdOut: "out", StdErr: "err", Output: "combined"})
+
+	require.Equal(t, []digestEntry{{config: cfg, result: RunResult{ContainerName: "a", ReturnCode: 1}}}, d.results)
+}
+
+func TestDigest_GroupsByRecipients(t *testing.T) {
+	rec := &digestRecorder{}
+	d := newDigest(time.Hour, rec.send)
+
+	ops := &MailConfig{MailTo: "ops@example.com", MailFrom: "crony@example.com"}
+	opsCopy := *ops
+	team := &MailConfig{MailTo: "team@example.com", MailFrom: "crony@example.com"}
+	d.add(ops, RunResult{ContainerName: "a"})
+	d.add(team, RunResult{ContainerName: "b"})
+	d.add(&opsCopy, RunResult{ContainerName: "c"})
+	d.flush()
+
+	require.Len(t, rec.sends, 2, "one digest per recipients")
+	require.Equal(t, "ops@example.com", rec.configs[0].MailTo)
+	require.Equal(t, []string{"a", "c"}, []string{rec.sends[0][0].ContainerName, rec.sends[0][1].ContainerName})
+	require.Equal(t, "team@example.com", rec.configs[1].MailTo)
+	require.Equal(t, "b", rec.sends[1][0].ContainerName)
+}
+
+func TestDigestTemplate_Renders(t *testing.T) {
+	params := newDigestParams([]RunResult{
+		{ContainerName: "backup", Duration: time.Second},
+		{ContainerName: "cleanup", ReturnCode: 3, Duration: time.Minute},
+		{ContainerName: "report", OutputFailure: "output matched: ERROR"},
+	})
+	require.Equal(t, 2, params.Failed)
+
+	var buf bytes.Buffer
+	require.NoError(t, newDigestTemplate().Execute(&buf, params))
+	body := buf.String()
+	require.Contains(t, body, "<b>3</b> job runs, <b>2</b> failed")
+	require.Contains(t, body, "<td>cleanup</td>")
+	require.Contains(t, body, "<td>1 minute</td>")
+	require.Contains(t, body, "output matched: ERROR")
+
+	require.Equal(t, "[DIGEST] ❌ 3 job runs, 2 failed", createDigestTopic(params))
+	require.Equal(t, "[DIGEST] ✔️ 1 job runs, all successful",
+		createDigestTopic(newDigestParams([]RunResult{{}})))
+}
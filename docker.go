@@ -14,9 +14,39 @@ import (
 )
 
 const (
-	mailPolicyLabel = "crony.mail_policy"
-	cronStringLabel = "crony.schedule"
-	hcUuidLabel     = "crony.hcio_uuid"
+	mailPolicyLabel                 = "crony.mail_policy"
+	mailToLabel                     = "crony.mail_to"
+	mailCcLabel                     = "crony.mail_cc"
+	mailBccLabel                    = "crony.mail_bcc"
+	mailFromLabel                   = "crony.mail_from"
+	mailSubjectTemplateFileLabel    = "crony.mail_subject_template_file"
+	mailBodyTemplateFileLabel       = "crony.mail_body_template_file"
+	mailAttachOutputLabel           = "crony.mail_attach_output"
+	cronStringLabel                 = "crony.schedule"
+	hcUuidLabel                     = "crony.hcio_uuid"
+	failIfOutputMatchesLabel        = "crony.fail_if_output_matches"
+	succeedOnlyIfOutputMatchesLabel = "crony.succeed_only_if_output_matches"
+	logOutputLabel                  = "crony.log_output"
+	captureHeadSizeLabel            = "crony.capture_head_size"
+	captureTailSizeLabel            = "crony.capture_tail_size"
+	webhookURLLabel                 = "crony.webhook_url"
+	webhookMethodLabel              = "crony.webhook_method"
+	webhookHeadersLabel             = "crony.webhook_headers"
+	webhookBodyTemplateLabel        = "crony.webhook_body_template"
+	webhookPolicyLabel              = "crony.webhook_policy"
+	chatWebhookURLLabel             = "crony.chat_webhook_url"
+	chatChannelLabel                = "crony.chat_channel"
+	chatPolicyLabel                 = "crony.chat_policy"
+	ntfyURLLabel                    = "crony.ntfy_url"
+	ntfyTopicLabel                  = "crony.ntfy_topic"
+	ntfyPolicyLabel                 = "crony.ntfy_policy"
+	gotifyURLLabel                  = "crony.gotify_url"
+	gotifyTokenLabel                = "crony.gotify_token"
+	gotifyPolicyLabel               = "crony.gotify_policy"
+	telegramChatIDLabel             = "crony.telegram_chat_id"
+	telegramThreadIDLabel           = "crony.telegram_thread_id"
+	telegramPolicyLabel             = "crony.telegram_policy"
+	alertmanagerURLLabel            = "crony.alertmanager_url"
 )
 
 type DockerClient struct {
@@ -57,11 +87,15 @@ func (d *DockerClient) RegisterDockerEventListeners(createFn OnContainerEvent, d
 		for {
 			select {
 			case err := <-errChan:
-				log.Error("got error on listening for new docker events: ", err)
+				log.WithError(err).Error("got error on listening for new docker events")
 			case msg := <-msg:
 				containerName := msg.Actor.Attributes["name"]
 				containerId := msg.Actor.ID
-				log.Infof("received event: '%s' from '%s'", msg.Action, containerName)
+				log.WithFields(log.Fields{
+					"job":          containerName,
+					"container_id": containerId,
+					"event":        msg.Action,
+				}).Info("received docker event")
 				//nolint:exhaustive // only create/destroy are subscribed via filter
 				switch msg.Action {
 				case events.ActionCreate:
@@ -77,6 +111,12 @@ func (d *DockerClient) RegisterDockerEventListeners(createFn OnContainerEvent, d
 
 type CronyContainer struct {
 	ID, Name, CronString, MailPolicy, HcUuid string
+	FailIfOutputMatches                      string
+	SucceedOnlyIfOutputMatches               string
+	LogOutput                                string
+	CaptureHeadSize, CaptureTailSize         string
+	// Labels holds all labels of the container, e.g. for notifier settings.
+	Labels map[string]string
 }
 
 func (d *DockerClient) GetCronyContainers(containerId string) ([]CronyContainer, error) {
@@ -99,6 +139,13 @@ func (d *DockerClient) GetCronyContainers(containerId string) ([]CronyContainer,
 				CronString: strings.Trim(c.Labels[cronStringLabel], "\""),
 				MailPolicy: c.Labels[mailPolicyLabel],
 				HcUuid:     c.Labels[hcUuidLabel],
+
+				FailIfOutputMatches:        c.Labels[failIfOutputMatchesLabel],
+				SucceedOnlyIfOutputMatches: c.Labels[succeedOnlyIfOutputMatchesLabel],
+				LogOutput:                  c.Labels[logOutputLabel],
+				CaptureHeadSize:            c.Labels[captureHeadSizeLabel],
+				CaptureTailSize:            c.Labels[captureTailSizeLabel],
+				Labels:                     c.Labels,
 			})
 		}
 
@@ -112,10 +159,15 @@ func (d *DockerClient) ContainerWait(name string) (<-chan container.WaitResponse
 	return d.cli.ContainerWait(context.Background(), name, container.WaitConditionNotRunning)
 }
 
+// ContainerLogs streams the output of the container since startTime, each line
+// prefixed with its timestamp. The stream follows the output as it is produced
+// and ends when the container stops.
 func (d *DockerClient) ContainerLogs(name string, startTime time.Time) (io.ReadCloser, error) {
 	return d.cli.ContainerLogs(context.Background(), name, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
 		Since:      startTime.Format("2006-01-02T15:04:05"),
 	})
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const gotifyTokenHeader = "X-Gotify-Key"
+
+// GotifyConfig configures push notifications via a Gotify server.
+type GotifyConfig struct {
+	URL             string        `envconfig:"gotify_url"`
+	Token           string        `envconfig:"gotify_token" secret:"true"`
+	PrioritySuccess int           `default:"2"              envconfig:"gotify_priority_success"`
+	PriorityFailure int           `default:"8"              envconfig:"gotify_priority_failure"`
+	Retries         int           `default:"3"              envconfig:"gotify_retries"`
+	Policy          MailPolicy    `default:"never"          envconfig:"gotify_policy"`
+	Timeout         time.Duration `default:"30s"            envconfig:"gotify_timeout"`
+}
+
+func (c GotifyConfig) String() string {
+	return fmt.Sprintf("gotify config [url=%s, policy=%s]", redactURL(c.URL), c.Policy)
+}
+
+// forContainer applies the container's label overrides.
+func (c GotifyConfig) forContainer(container CronyContainer) (GotifyConfig, error) {
+	if v, ok := container.Labels[gotifyURLLabel]; ok {
+		c.URL = v
+	}
+	if v, ok := container.Labels[gotifyTokenLabel]; ok {
+		c.Token = v
+	}
+	if v, ok := container.Labels[gotifyPolicyLabel]; ok {
+		if err := c.Policy.Decode(v); err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", gotifyPolicyLabel, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c GotifyConfig) notification(container CronyContainer) (*notification, error) {
+	cfg, err := c.forContainer(container)
+	if err != nil || cfg.URL == "" || cfg.Token == "" {
+		return nil, err
+	}
+
+	notifier, err := newGotifyNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification{notifier: notifier, policy: cfg.Policy, timeout: cfg.Timeout}, nil
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+// gotifyNotifier sends the result of a job run as Gotify message.
+type gotifyNotifier struct {
+	config GotifyConfig
+	client *http.Client
+}
+
+func newGotifyNotifier(config GotifyConfig) (*gotifyNotifier, error) {
+	if config.URL == "" || config.Token == "" {
+		return nil, errors.New("gotify URL and app token must be provided")
+	}
+	for _, p := range []int{config.PrioritySuccess, config.PriorityFailure} {
+		if p < 0 {
+			return nil, fmt.Errorf("invalid gotify priority %d, must not be negative", p)
+		}
+	}
+
+	return &gotifyNotifier{config: config, client: &http.Client{}}, nil
+}
+
+func (n *gotifyNotifier) Name() string {
+	return "gotify"
+}
+
+func (n *gotifyNotifier) Notify(ctx context.Context, result RunResult) error {
+	msg := gotifyMessage{
+		Title:    createTopic(result),
+		Message:  pushMessage(result),
+		Priority: n.config.PrioritySuccess,
+	}
+	if !result.Success() {
+		msg.Priority = n.config.PriorityFailure
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(n.config.URL, "/") + "/message"
+
+	err = postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(gotifyTokenHeader, n.config.Token)
+
+		return req, nil
+	})
+
+	return redactError(err, urlSecrets(url)...)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGotifyNotifier_Notify(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newGotifyNotifier(GotifyConfig{URL: srv.URL, Token: "app-token", PrioritySuccess: 2, PriorityFailure: 8})
+	require.NoError(t, err)
+
+	result := RunResult{ContainerName: "backup", ReturnCode: 1, StdOut: "done\n"}
+	require.NoError(t, n.Notify(context.Background(), result))
+
+	req := <-requests
+	require.Equal(t, "app-token", req.header.Get(gotifyTokenHeader))
+
+	var msg gotifyMessage
+	require.NoError(t, json.Unmarshal(req.body, &msg))
+	require.Equal(t, createTopic(result), msg.Title)
+	require.Equal(t, "done", msg.Message)
+	require.Equal(t, 8, msg.Priority)
+}
+
+func TestGotifyNotifier_InvalidConfig(t *testing.T) {
+	_, err := newGotifyNotifier(GotifyConfig{URL: "http://localhost"})
+	require.Error(t, err)
+
+	_, err = newGotifyNotifier(GotifyConfig{URL: "http://localhost", Token: "t", PriorityFailure: -1})
+	require.ErrorContains(t, err, "priority")
+}
+
+func TestGotifyConfig_Notification(t *testing.T) {
+	global := GotifyConfig{URL: "http://localhost", Policy: OnError}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no token configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{
+		gotifyTokenLabel:  "app-token",
+		gotifyPolicyLabel: "always",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, Always, n.policy)
+	require.Equal(t, "gotify", n.notifier.Name())
+
+	_, err = global.notification(CronyContainer{Labels: map[string]string{
+		gotifyTokenLabel:  "app-token",
+		gotifyPolicyLabel: "sometimes",
+	}})
+	require.ErrorContains(t, err, gotifyPolicyLabel)
+}
@@ -2,23 +2,72 @@ package healthchecks
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
-const DefaultBaseURL = "https://hc-ping.com/"
+const (
+	DefaultBaseURL = "https://hc-ping.com/"
+	// DefaultTimeout limits each ping request, unless overridden by
+	// WithTimeout.
+	DefaultTimeout = 10 * time.Second
+
+	maxAttempts = 3
+)
 
 type Check struct {
 	ID      string
 	BaseURL string
+
+	client  *http.Client
+	timeout time.Duration
+	proxy   *url.URL
+	rootCAs *x509.CertPool
+}
+
+// Option configures a Check.
+type Option func(*Check)
+
+// WithHTTPClient sends the pings with client. Proxy and CA options are
+// ignored, as they are part of the client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Check) {
+		c.client = client
+	}
+}
+
+// WithTimeout limits each ping request to timeout, 0 disables the limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Check) {
+		c.timeout = timeout
+	}
+}
+
+// WithProxy sends the pings via the proxy instead of the proxy configured
+// by the environment.
+func WithProxy(proxy *url.URL) Option {
+	return func(c *Check) {
+		c.proxy = proxy
+	}
 }
 
-func NewCheck(id, baseURL string) *Check {
+// WithRootCAs verifies the server with the CAs instead of the system CAs,
+// e.g. for self-hosted instances.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Check) {
+		c.rootCAs = pool
+	}
+}
+
+func NewCheck(id, baseURL string, opts ...Option) *Check {
 	if baseURL == "" {
 		baseURL = DefaultBaseURL
 	}
@@ -26,63 +75,117 @@ func NewCheck(id, baseURL string) *Check {
 		baseURL += "/"
 	}
 
-	return &Check{ID: id, BaseURL: baseURL}
-}
+	c := &Check{ID: id, BaseURL: baseURL, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.client == nil {
+		c.client = c.newClient()
+	}
 
-func (c *Check) Start() error {
-	return c.sendPing(fmt.Sprintf("%s%s/start", c.BaseURL, c.ID), "")
+	return c
 }
 
-func (c *Check) Ping(code int64, message string) error {
-	return c.sendPing(fmt.Sprintf("%s%s/%d", c.BaseURL, c.ID, code), message)
+// NewClient returns an HTTP client applying the proxy and CA options, to be
+// shared by many checks with WithHTTPClient.
+func NewClient(opts ...Option) *http.Client {
+	c := &Check{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c.newClient()
 }
 
-func (c *Check) sendPing(url string, message string) error {
-	r, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, strings.NewReader(message))
-	if err != nil {
-		return err
+func (c *Check) newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // always a *http.Transport
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	if c.rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: c.rootCAs, MinVersion: tls.VersionTLS12}
 	}
 
-	var attempts uint8
-	var response *http.Response
+	return &http.Client{Transport: transport}
+}
 
-	for attempts < 3 {
-		if attempts != 0 {
-			time.Sleep(time.Duration(math.Pow(2, float64(attempts-1))) * time.Second)
-		}
+func (c *Check) Start(ctx context.Context) error {
+	return c.sendPing(ctx, fmt.Sprintf("%s%s/start", c.BaseURL, c.ID), "")
+}
 
-		response, err = http.DefaultClient.Do(r)
-		if err != nil {
-			attempts++
+func (c *Check) Ping(ctx context.Context, code int64, message string) error {
+	return c.sendPing(ctx, fmt.Sprintf("%s%s/%d", c.BaseURL, c.ID, code), message)
+}
 
-			continue
-		}
+// Fail signals a failure independent of the exit code of the job.
+func (c *Check) Fail(ctx context.Context, message string) error {
+	return c.sendPing(ctx, fmt.Sprintf("%s%s/fail", c.BaseURL, c.ID), message)
+}
 
-		break
-	}
+func (c *Check) sendPing(ctx context.Context, url string, message string) error {
+	var err error
 
-	if response != nil {
-		defer response.Body.Close()
+	for attempt := range maxAttempts {
+		if attempt != 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+			case <-time.After(time.Duration(math.Pow(2, float64(attempt-1))) * time.Second):
+			}
+		}
 
-		b, err := io.ReadAll(response.Body)
-		if err != nil {
+		var body string
+		body, err = c.post(ctx, url, message)
+		if err == nil {
+			return checkResponse(c.ID, body)
+		}
+		if ctx.Err() != nil {
 			return err
 		}
-		body := string(b)
+	}
 
-		switch body {
-		case "OK":
-			return nil
+	return err
+}
 
-		case "OK (not found)":
-			return fmt.Errorf("the server could not find a check with ID: %q", c.ID)
+// post sends a single ping and returns the response body.
+func (c *Check) post(ctx context.Context, url string, message string) (string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
 
-		case "OK (rate limited)":
-			return errors.New("the server indicates the check was pinged too frequently (5+ times in one minute)")
-		}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(message))
+	if err != nil {
+		return "", err
+	}
 
-		return fmt.Errorf("the server returned an unknown response: %v", body)
+	response, err := c.client.Do(r)
+	if err != nil {
+		return "", err
 	}
+	defer response.Body.Close()
 
-	return err
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func checkResponse(id, body string) error {
+	switch body {
+	case "OK":
+		return nil
+
+	case "OK (not found)":
+		return fmt.Errorf("the server could not find a check with ID: %q", id)
+
+	case "OK (rate limited)":
+		return errors.New("the server indicates the check was pinged too frequently (5+ times in one minute)")
+	}
+
+	return fmt.Errorf("the server returned an unknown response: %v", body)
 }
@@ -0,0 +1,132 @@
+package healthchecks
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ping struct {
+	path, body string
+}
+
+// hcServer answers pings with the responses, repeating the last one. A
+// response of "stall" blocks until the request is cancelled.
+func hcServer(t *testing.T, responses ...string) (*httptest.Server, chan ping) {
+	t.Helper()
+	pings := make(chan ping, 10)
+	var count atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		pings <- ping{path: r.URL.Path, body: string(b)}
+
+		response := responses[min(int(count.Add(1)), len(responses))-1]
+		if response == "stall" {
+			<-r.Context().Done()
+
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, pings
+}
+
+func TestNewCheck_BaseURL(t *testing.T) {
+	assert.Equal(t, DefaultBaseURL, NewCheck("id", "").BaseURL)
+	assert.Equal(t, "http://hc.example.com/ping/", NewCheck("id", "http://hc.example.com/ping").BaseURL)
+}
+
+func TestCheck_Pings(t *testing.T) {
+	srv, pings := hcServer(t, "OK")
+	c := NewCheck("uuid", srv.URL)
+
+	require.NoError(t, c.Start(context.Background()))
+	assert.Equal(t, ping{path: "/uuid/start"}, <-pings)
+
+	require.NoError(t, c.Ping(context.Background(), 3, "output"))
+	assert.Equal(t, ping{path: "/uuid/3", body: "output"}, <-pings)
+
+	require.NoError(t, c.Fail(context.Background(), "reason"))
+	assert.Equal(t, ping{path: "/uuid/fail", body: "reason"}, <-pings)
+}
+
+func TestCheck_ErrorResponses(t *testing.T) {
+	srv, _ := hcServer(t, "OK (not found)", "OK (rate limited)", "unexpected")
+	c := NewCheck("uuid", srv.URL)
+
+	require.ErrorContains(t, c.Start(context.Background()), "could not find a check")
+	require.ErrorContains(t, c.Start(context.Background()), "too frequently")
+	require.ErrorContains(t, c.Start(context.Background()), "unknown response")
+}
+
+func TestCheck_TimeoutAndRetry(t *testing.T) {
+	srv, pings := hcServer(t, "stall", "OK")
+	c := NewCheck("uuid", srv.URL, WithTimeout(50*time.Millisecond))
+
+	require.NoError(t, c.Ping(context.Background(), 0, "output"))
+	assert.Equal(t, ping{path: "/uuid/0", body: "output"}, <-pings)
+	assert.Equal(t, ping{path: "/uuid/0", body: "output"}, <-pings, "the message is sent again")
+}
+
+func TestCheck_Cancel(t *testing.T) {
+	srv, _ := hcServer(t, "stall")
+	c := NewCheck("uuid", srv.URL, WithTimeout(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	require.ErrorIs(t, c.Start(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "no retries after cancellation")
+}
+
+func TestCheck_Proxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	c := NewCheck("uuid", "http://hc.invalid/", WithProxy(proxyURL))
+	require.NoError(t, c.Start(context.Background()))
+	assert.Equal(t, "http://hc.invalid/uuid/start", proxied.Load())
+
+	c = NewCheck("other", "http://hc.invalid/", WithHTTPClient(NewClient(WithProxy(proxyURL))))
+	require.NoError(t, c.Start(context.Background()))
+	assert.Equal(t, "http://hc.invalid/other/start", proxied.Load())
+}
+
+func TestCheck_RootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	require.ErrorContains(t, NewCheck("uuid", srv.URL).Start(ctx), "certificate")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	require.NoError(t, NewCheck("uuid", srv.URL, WithRootCAs(pool)).Start(context.Background()))
+
+	require.NoError(t, NewCheck("uuid", srv.URL, WithHTTPClient(srv.Client())).Start(context.Background()))
+
+	client := NewClient(WithRootCAs(pool))
+	require.NoError(t, NewCheck("uuid", srv.URL, WithHTTPClient(client)).Start(context.Background()))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxErrorBodyLength caps the part of an error response included in errors.
+const maxErrorBodyLength = 512
+
+// sendWithRetry sends the request created by newRequest, retrying with
+// exponential backoff on transport errors, 429 and 5xx responses. Any other
+// non-2xx response fails immediately. It returns the successful response with
+// an unread body, which the caller must close.
+func sendWithRetry(ctx context.Context, client *http.Client, retries int,
+	newRequest func(ctx context.Context) (*http.Request, error),
+) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(math.Pow(2, float64(attempt-1))) * time.Second
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w (last error: %w)", ctx.Err(), lastErr)
+			case <-time.After(backoff):
+			}
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		lastErr = responseError(resp)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+// postWithRetry is sendWithRetry for requests without a response of interest.
+func postWithRetry(ctx context.Context, client *http.Client, retries int,
+	newRequest func(ctx context.Context) (*http.Request, error),
+) error {
+	resp, err := sendWithRetry(ctx, client, retries, newRequest)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.Body.Close()
+}
+
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+
+	return fmt.Errorf("the server returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
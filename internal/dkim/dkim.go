@@ -0,0 +1,229 @@
+// Package dkim signs mails with DomainKeys Identified Mail (RFC 6376) using
+// rsa-sha256 or ed25519-sha256 (RFC 8463) and relaxed canonicalization of
+// headers and body.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders are the headers signed if no others are given.
+//
+//nolint:gochecknoglobals // read-only list
+var DefaultHeaders = []string{
+	"From", "To", "Cc", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+}
+
+// Signer adds a DKIM-Signature header to mails.
+type Signer struct {
+	domain    string
+	selector  string
+	headers   []string
+	key       crypto.Signer
+	algorithm string
+	now       func() time.Time
+}
+
+// NewSigner returns a Signer for the domain and selector with the PEM encoded
+// private key, an RSA key in PKCS #1 or PKCS #8 format or an Ed25519 key in
+// PKCS #8 format. headers are the names of the headers to sign, From is
+// always signed.
+func NewSigner(domain, selector string, keyPEM []byte, headers []string) (*Signer, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: domain and selector are required")
+	}
+
+	key, algorithm, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	if !slices.ContainsFunc(headers, func(h string) bool { return strings.EqualFold(h, "From") }) {
+		headers = append([]string{"From"}, headers...)
+	}
+
+	return &Signer{
+		domain:    domain,
+		selector:  selector,
+		headers:   headers,
+		key:       key,
+		algorithm: algorithm,
+		now:       time.Now,
+	}, nil
+}
+
+func parseKey(keyPEM []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, "", errors.New("dkim: no PEM encoded private key found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("dkim: unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("dkim: can't parse private key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return k, "ed25519-sha256", nil
+	default:
+		return nil, "", fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+}
+
+// Sign returns msg, a mail with CRLF line endings, with a DKIM-Signature
+// header prepended.
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("dkim: mail has no body")
+	}
+	fields := parseHeader(header)
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+
+	var names []string
+	var data bytes.Buffer
+	used := map[int]bool{}
+	for _, name := range s.headers {
+		// multiple instances are signed from the bottom up
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				names = append(names, strings.ToLower(name))
+				data.WriteString(canonicalHeader(fields[i].raw))
+
+				break
+			}
+		}
+	}
+
+	sigHeader := "DKIM-Signature: v=1; a=" + s.algorithm + "; c=relaxed/relaxed;\r\n" +
+		" d=" + s.domain + "; s=" + s.selector + "; t=" + strconv.FormatInt(s.now().Unix(), 10) + ";\r\n" +
+		" h=" + strings.Join(names, ":") + ";\r\n" +
+		" bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + ";\r\n" +
+		" b="
+	data.WriteString(strings.TrimSuffix(canonicalHeader(sigHeader), "\r\n"))
+
+	signature, err := s.sign(data.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("dkim: can't sign mail: %w", err)
+	}
+
+	signed := make([]byte, 0, len(sigHeader)+len(signature)+len(msg)+2)
+	signed = append(signed, sigHeader...)
+	signed = append(signed, base64.StdEncoding.EncodeToString(signature)...)
+	signed = append(signed, "\r\n"...)
+
+	return append(signed, msg...), nil
+}
+
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		// RFC 8463 signs the hash, not the data itself
+		return s.key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	}
+
+	return s.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+type headerField struct {
+	name string
+	// raw is the complete field including folding and the final CRLF
+	raw string
+}
+
+func parseHeader(header []byte) []headerField {
+	var fields []headerField
+	for line := range strings.SplitAfterSeq(string(header)+"\r\n", "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+
+	return fields
+}
+
+// canonicalHeader applies the relaxed header canonicalization to a field.
+func canonicalHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(compressWSP(value)) + "\r\n"
+}
+
+// canonicalBody applies the relaxed body canonicalization.
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(compressWSP(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compressWSP replaces sequences of spaces and tabs by a single space.
+func compressWSP(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+
+	return b.String()
+}
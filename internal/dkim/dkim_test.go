@@ -0,0 +1,207 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the example of RFC 8463, appendix A
+const (
+	rfcMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+	rfcSeed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfcBodyHash  = "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="
+	rfcSignature = "/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11BusFa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw=="
+)
+
+func TestSign_RFC8463Vector(t *testing.T) {
+	header, body, _ := strings.Cut(rfcMessage, "\r\n\r\n")
+
+	bodyHash := sha256.Sum256(canonicalBody([]byte(body)))
+	assert.Equal(t, rfcBodyHash, base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	var data string
+	for _, f := range parseHeader([]byte(header)) {
+		data += canonicalHeader(f.raw)
+	}
+	data += strings.TrimSuffix(canonicalHeader("DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n"+
+		" d=football.example.com; i=@football.example.com;\r\n"+
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n"+
+		" subject : date : message-id : from : subject : date;\r\n"+
+		" bh="+rfcBodyHash+";\r\n"+
+		" b="), "\r\n")
+
+	seed, _ := base64.StdEncoding.DecodeString(rfcSeed)
+	s := &Signer{key: ed25519.NewKeyFromSeed(seed)}
+	signature, err := s.sign([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, rfcSignature, base64.StdEncoding.EncodeToString(signature))
+}
+
+func TestCanonicalHeader(t *testing.T) {
+	assert.Equal(t, "subject:Is dinner ready?\r\n", canonicalHeader("SUBJECT \t:  Is \t dinner\r\n\t ready?  \r\n"))
+}
+
+func TestCanonicalBody(t *testing.T) {
+	assert.Equal(t, " C\r\nD E\r\n", string(canonicalBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))))
+	assert.Empty(t, canonicalBody([]byte("\r\n\r\n")))
+	assert.Equal(t, "no newline\r\n", string(canonicalBody([]byte("no newline"))))
+}
+
+func pemKey(t *testing.T, typ string, der []byte) []byte {
+	t.Helper()
+
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+// verify checks the DKIM-Signature of msg with pub and returns its tags.
+func verify(t *testing.T, msg []byte, pub crypto.PublicKey) map[string]string {
+	t.Helper()
+	header, body, ok := strings.Cut(string(msg), "\r\n\r\n")
+	require.True(t, ok)
+	fields := parseHeader([]byte(header))
+	require.Equal(t, "DKIM-Signature", fields[0].name)
+
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(fields[0].raw, ":")
+	for tag := range strings.SplitSeq(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = regexp.MustCompile(`\s`).ReplaceAllString(v, "")
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody([]byte(body)))
+	require.Equal(t, base64.StdEncoding.EncodeToString(bodyHash[:]), tags["bh"], "body hash")
+
+	var data string
+	used := map[int]bool{}
+	for name := range strings.SplitSeq(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				data += canonicalHeader(fields[i].raw)
+
+				break
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(fields[0].raw, "b=")
+	data += strings.TrimSuffix(canonicalHeader(unsigned), "\r\n")
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	require.NoError(t, err)
+	hash := sha256.Sum256([]byte(data))
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		require.NoError(t, rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature))
+	case ed25519.PublicKey:
+		require.True(t, ed25519.Verify(k, hash[:], signature))
+	}
+
+	return tags
+}
+
+func TestSigner_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, keyPEM := range [][]byte{
+		pemKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		pemKey(t, "PRIVATE KEY", must(x509.MarshalPKCS8PrivateKey(key))),
+	} {
+		s, err := NewSigner("example.com", "crony", keyPEM, nil)
+		require.NoError(t, err)
+		s.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+		signed, err := s.Sign([]byte(rfcMessage))
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(string(signed), rfcMessage))
+
+		tags := verify(t, signed, &key.PublicKey)
+		assert.Equal(t, "rsa-sha256", tags["a"])
+		assert.Equal(t, "relaxed/relaxed", tags["c"])
+		assert.Equal(t, "example.com", tags["d"])
+		assert.Equal(t, "crony", tags["s"])
+		assert.Equal(t, "1700000000", tags["t"])
+		assert.Equal(t, "from:to:subject:date:message-id", tags["h"], "only present headers are listed")
+	}
+}
+
+func TestSigner_Ed25519(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := NewSigner("example.com", "crony", pemKey(t, "PRIVATE KEY", must(x509.MarshalPKCS8PrivateKey(key))),
+		[]string{"Subject", "Subject"})
+	require.NoError(t, err)
+
+	msg := strings.Replace(rfcMessage, "Subject:", "Subject: first\r\nSubject:", 1)
+	signed, err := s.Sign([]byte(msg))
+	require.NoError(t, err)
+
+	tags := verify(t, signed, pub)
+	assert.Equal(t, "ed25519-sha256", tags["a"])
+	assert.Equal(t, "from:subject:subject", tags["h"], "From is always signed")
+
+	// a modified body breaks the signature
+	tampered := []byte(strings.Replace(string(signed), "hungry", "angry", 1))
+	bodyHash := sha256.Sum256(canonicalBody([]byte(strings.SplitN(string(tampered), "\r\n\r\n", 2)[1])))
+	assert.NotEqual(t, base64.StdEncoding.EncodeToString(bodyHash[:]), tags["bh"])
+}
+
+func TestNewSigner_Errors(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyPEM := pemKey(t, "PRIVATE KEY", must(x509.MarshalPKCS8PrivateKey(key)))
+
+	_, err = NewSigner("", "crony", keyPEM, nil)
+	require.ErrorContains(t, err, "domain and selector")
+
+	_, err = NewSigner("example.com", "crony", []byte("no key"), nil)
+	require.ErrorContains(t, err, "no PEM")
+
+	_, err = NewSigner("example.com", "crony", pemKey(t, "CERTIFICATE", []byte{1}), nil)
+	require.ErrorContains(t, err, "unsupported PEM block")
+
+	_, err = NewSigner("example.com", "crony", pemKey(t, "PRIVATE KEY", []byte{1}), nil)
+	require.ErrorContains(t, err, "can't parse private key")
+}
+
+func TestSign_WithoutBody(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	s, err := NewSigner("example.com", "crony", pemKey(t, "PRIVATE KEY", must(x509.MarshalPKCS8PrivateKey(key))), nil)
+	require.NoError(t, err)
+
+	_, err = s.Sign([]byte("From: a@example.com\r\n"))
+	require.Error(t, err)
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
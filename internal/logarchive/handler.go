@@ -0,0 +1,86 @@
+package logarchive
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+// RegisterHandlers registers the archive API on mux:
+//
+//	GET /api/logs/{job}                 lists the archived files of a job
+//	GET /api/logs/{job}/{run}/{stream}  downloads an archived file (gzip)
+//
+// Requests must carry the token as bearer token in the Authorization header.
+func (a *Archive) RegisterHandlers(mux *http.ServeMux, token string) {
+	mux.Handle("GET /api/logs/{job}", requireToken(token, a.handleList))
+	mux.Handle("GET /api/logs/{job}/{run}/{stream}", requireToken(token, a.handleDownload))
+}
+
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (a *Archive) handleList(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.List(r.PathValue("job"))
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func (a *Archive) handleDownload(w http.ResponseWriter, r *http.Request) {
+	job, runID, stream := r.PathValue("job"), r.PathValue("run"), r.PathValue("stream")
+
+	f, err := a.Open(job, runID, stream)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", job+"-"+runID+"."+stream+fileSuffix))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
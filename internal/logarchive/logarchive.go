@@ -0,0 +1,302 @@
+// Package logarchive stores the complete output of job runs as gzip-compressed
+// files on disk, one file per job run and stream, and enforces age- and
+// size-based retention.
+//
+// Files are laid out as <dir>/<job>/<run id>.<stream>.log.gz.
+package logarchive
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileSuffix    = ".log.gz"
+	partialSuffix = ".partial"
+	dirPerm       = 0o750
+)
+
+// Streams which can be archived. Combined holds the chronologically
+// interleaved stdout and stderr.
+const (
+	Stdout   = "stdout"
+	Stderr   = "stderr"
+	Combined = "combined"
+)
+
+var streams = []string{Stdout, Stderr, Combined}
+
+// ErrInvalidName is returned for job names, run IDs or streams which can't be
+// used as part of a file name.
+var ErrInvalidName = errors.New("invalid name")
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Archive is a directory of archived job output. It is safe for concurrent use.
+type Archive struct {
+	dir     string
+	maxAge  time.Duration
+	maxSize int64
+	// started is the time the archive was opened, partial files older than
+	// it are left over from a previous process
+	started time.Time
+
+	mu sync.Mutex
+}
+
+// Entry describes a single archived file.
+type Entry struct {
+	Job     string    `json:"job"`
+	RunID   string    `json:"run_id"`
+	Stream  string    `json:"stream"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"time"`
+}
+
+// New returns an Archive storing files in dir, creating it if necessary.
+// Files older than maxAge are removed on Prune, as are the oldest files if the
+// total size exceeds maxSize. Zero values disable the respective limit.
+func New(dir string, maxAge time.Duration, maxSize int64) (*Archive, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("can't create log archive directory: %w", err)
+	}
+
+	return &Archive{dir: dir, maxAge: maxAge, maxSize: maxSize, started: time.Now()}, nil
+}
+
+// Run holds the open archive files of a single job run.
+type Run struct {
+	files []*file
+}
+
+type file struct {
+	f    *os.File
+	gz   *gzip.Writer
+	path string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return f.gz.Write(p)
+}
+
+func (f *file) close() error {
+	err := errors.Join(f.gz.Close(), f.f.Close())
+	if err != nil {
+		_ = os.Remove(f.f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.f.Name(), f.path)
+}
+
+// Create starts archiving a job run. Output written to the returned Run's
+// streams is only visible in the archive after Close.
+func (a *Archive) Create(job, runID string) (*Run, error) {
+	if !validName.MatchString(job) || !validName.MatchString(runID) {
+		return nil, ErrInvalidName
+	}
+
+	// Prune removes empty job directories
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	jobDir := filepath.Join(a.dir, job)
+	if err := os.MkdirAll(jobDir, dirPerm); err != nil {
+		return nil, fmt.Errorf("can't create log archive directory: %w", err)
+	}
+
+	r := &Run{}
+	for _, stream := range streams {
+		path := filepath.Join(jobDir, runID+"."+stream+fileSuffix)
+		f, err := os.Create(path + partialSuffix)
+		if err != nil {
+			r.abort()
+
+			return nil, fmt.Errorf("can't create log archive file: %w", err)
+		}
+		r.files = append(r.files, &file{f: f, gz: gzip.NewWriter(f), path: path})
+	}
+
+	return r, nil
+}
+
+// Stdout returns the writer for the stdout stream of the run.
+func (r *Run) Stdout() io.Writer {
+	return r.files[0]
+}
+
+// Stderr returns the writer for the stderr stream of the run.
+func (r *Run) Stderr() io.Writer {
+	return r.files[1]
+}
+
+// Combined returns the writer for the combined stream of the run.
+func (r *Run) Combined() io.Writer {
+	return r.files[2]
+}
+
+// Close flushes and finalizes the archive files of the run.
+func (r *Run) Close() error {
+	var errs []error
+	for _, f := range r.files {
+		errs = append(errs, f.close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Run) abort() {
+	for _, f := range r.files {
+		_ = f.f.Close()
+		_ = os.Remove(f.f.Name())
+	}
+}
+
+// Open opens an archived file for reading. The content is gzip-compressed.
+func (a *Archive) Open(job, runID, stream string) (*os.File, error) {
+	if !validName.MatchString(job) || !validName.MatchString(runID) || !slices.Contains(streams, stream) {
+		return nil, ErrInvalidName
+	}
+
+	return os.Open(filepath.Join(a.dir, job, runID+"."+stream+fileSuffix))
+}
+
+// List returns the archived files of the given job, newest first.
+func (a *Archive) List(job string) ([]Entry, error) {
+	if !validName.MatchString(job) {
+		return nil, ErrInvalidName
+	}
+
+	entries, err := a.entries(filepath.Join(a.dir, job))
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+
+	return entries, nil
+}
+
+// Prune removes files exceeding the configured age or total size, and the
+// partial files of runs which were never finished, e.g. because crony was
+// killed during the run.
+func (a *Archive) Prune() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.prunePartials(); err != nil {
+		return err
+	}
+
+	entries, err := a.entries(a.dir)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	var errs []error
+	for _, e := range entries {
+		expired := a.maxAge > 0 && time.Since(e.ModTime) > a.maxAge
+		oversized := a.maxSize > 0 && total > a.maxSize
+		if !expired && !oversized {
+			continue
+		}
+
+		if err := os.Remove(a.path(e)); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+		total -= e.Size
+		// removing the job directory fails as long as it is not empty
+		_ = os.Remove(filepath.Join(a.dir, e.Job))
+	}
+
+	return errors.Join(errs...)
+}
+
+// prunePartials removes partial files created before the archive was opened
+// or older than the age limit.
+func (a *Archive) prunePartials() error {
+	var errs []error
+	err := filepath.WalkDir(a.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), partialSuffix) {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stale := info.ModTime().Before(a.started) || (a.maxAge > 0 && time.Since(info.ModTime()) > a.maxAge)
+		if stale {
+			if err := os.Remove(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return nil
+	})
+
+	return errors.Join(append(errs, err)...)
+}
+
+func (a *Archive) path(e Entry) string {
+	return filepath.Join(a.dir, e.Job, e.RunID+"."+e.Stream+fileSuffix)
+}
+
+// entries returns all finished archive files below root, oldest first.
+func (a *Archive) entries(root string) ([]Entry, error) {
+	var result []Entry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+		name, ok := strings.CutSuffix(d.Name(), fileSuffix)
+		if d.IsDir() || !ok {
+			return nil
+		}
+		dot := strings.LastIndexByte(name, '.')
+		if dot < 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		result = append(result, Entry{
+			Job:     filepath.Base(filepath.Dir(path)),
+			RunID:   name[:dot],
+			Stream:  name[dot+1:],
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+
+		return nil
+	})
+
+	slices.SortStableFunc(result, func(x, y Entry) int {
+		return x.ModTime.Compare(y.ModTime)
+	})
+
+	return result, err
+}
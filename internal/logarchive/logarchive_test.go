@@ -0,0 +1,272 @@
+package logarchive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archiveRun(t *testing.T, a *Archive, job, runID, stdout, stderr string) {
+	t.Helper()
+	r, err := a.Create(job, runID)
+	require.NoError(t, err)
+	_, _ = io.WriteString(r.Stdout(), stdout)
+	_, _ = io.WriteString(r.Stderr(), stderr)
+	_, _ = io.WriteString(r.Combined(), stdout+stderr)
+	require.NoError(t, r.Close())
+}
+
+func readArchived(t *testing.T, a *Archive, job, runID, stream string) string {
+	t.Helper()
+	f, err := a.Open(job, runID, stream)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestCreateAndOpen(t *testing.T) {
+	a, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	archiveRun(t, a, "backup", "abc123", "hello stdout", "boom stderr")
+
+	assert.Equal(t, "hello stdout", readArchived(t, a, "backup", "abc123", Stdout))
+	assert.Equal(t, "boom stderr", readArchived(t, a, "backup", "abc123", Stderr))
+	assert.Equal(t, "hello stdoutboom stderr", readArchived(t, a, "backup", "abc123", Combined))
+}
+
+func TestRunNotVisibleBeforeClose(t *testing.T) {
+	a, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	r, err := a.Create("backup", "abc123")
+	require.NoError(t, err)
+
+	entries, err := a.List("backup")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, r.Close())
+	entries, err = a.List("backup")
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestInvalidNames(t *testing.T) {
+	a, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	for _, name := range []string{"", "..", "../etc", "a/b", ".hidden"} {
+		_, err := a.Create(name, "abc")
+		require.ErrorIs(t, err, ErrInvalidName, name)
+		_, err = a.Create("job", name)
+		require.ErrorIs(t, err, ErrInvalidName, name)
+		_, err = a.Open("job", name, Stdout)
+		require.ErrorIs(t, err, ErrInvalidName, name)
+	}
+	_, err = a.Open("job", "abc", "passwd")
+	require.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestList_NewestFirst(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(dir, 0, 0)
+	require.NoError(t, err)
+
+	archiveRun(t, a, "backup", "old", "1", "")
+	archiveRun(t, a, "backup", "new", "2", "")
+	archiveRun(t, a, "other", "x", "3", "")
+	setAge(t, dir, "backup", "old", time.Hour)
+
+	entries, err := a.List("backup")
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+	assert.Equal(t, "new", entries[0].RunID)
+	assert.Equal(t, "old", entries[5].RunID)
+	assert.Equal(t, "backup", entries[0].Job)
+}
+
+func TestPrune_ByAge(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(dir, 24*time.Hour, 0)
+	require.NoError(t, err)
+
+	archiveRun(t, a, "backup", "old", "1", "")
+	archiveRun(t, a, "backup", "new", "2", "")
+	archiveRun(t, a, "gone", "old", "3", "")
+	setAge(t, dir, "backup", "old", 48*time.Hour)
+	setAge(t, dir, "gone", "old", 48*time.Hour)
+
+	require.NoError(t, a.Prune())
+
+	entries, err := a.List("backup")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "new", entries[0].RunID)
+
+	_, err = os.Stat(filepath.Join(dir, "gone"))
+	assert.True(t, os.IsNotExist(err), "empty job directory should be removed")
+}
+
+func TestPrune_BySize(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(dir, 0, 1)
+	require.NoError(t, err)
+
+	archiveRun(t, a, "backup", "old", strings.Repeat("a", 100), "")
+	archiveRun(t, a, "backup", "new", strings.Repeat("b", 100), "")
+	setAge(t, dir, "backup", "old", time.Hour)
+
+	entries, err := a.List("backup")
+	require.NoError(t, err)
+	var newSize int64
+	for _, e := range entries {
+		if e.RunID == "new" {
+			newSize += e.Size
+		}
+	}
+	a.maxSize = newSize
+
+	require.NoError(t, a.Prune())
+
+	entries, err = a.List("backup")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for _, e := range entries {
+		assert.Equal(t, "new", e.RunID)
+	}
+}
+
+func TestPrune_StalePartials(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(dir, 24*time.Hour, 0)
+	require.NoError(t, err)
+
+	leftover, err := a.Create("backup", "leftover")
+	require.NoError(t, err)
+	defer func() { _ = leftover.Close() }()
+	old, err := a.Create("backup", "old")
+	require.NoError(t, err)
+	defer func() { _ = old.Close() }()
+	running, err := a.Create("backup", "running")
+	require.NoError(t, err)
+
+	partial := func(runID string) string {
+		return filepath.Join(dir, "backup", runID+"."+Stdout+fileSuffix+partialSuffix)
+	}
+	setModTime := func(runID string, ts time.Time) {
+		for _, stream := range streams {
+			path := filepath.Join(dir, "backup", runID+"."+stream+fileSuffix+partialSuffix)
+			require.NoError(t, os.Chtimes(path, ts, ts))
+		}
+	}
+	// leftover was created before the archive was opened
+	a.started = time.Now().Add(time.Minute)
+	setModTime("running", time.Now().Add(2*time.Minute))
+	setModTime("old", time.Now().Add(-48*time.Hour))
+
+	require.NoError(t, a.Prune())
+
+	require.NoFileExists(t, partial("leftover"), "left over from a previous process")
+	require.NoFileExists(t, partial("old"), "older than the age limit")
+	require.FileExists(t, partial("running"))
+	require.NoError(t, running.Close())
+}
+
+func TestHandlers(t *testing.T) {
+	a, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	archiveRun(t, a, "backup", "abc123", "hello stdout", "")
+
+	mux := http.NewServeMux()
+	a.RegisterHandlers(mux, "s3cr3t")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(t *testing.T, path, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("list", func(t *testing.T) {
+		resp := get(t, "/api/logs/backup", "s3cr3t")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var entries []Entry
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+		assert.Len(t, entries, 3)
+	})
+	t.Run("download", func(t *testing.T) {
+		resp := get(t, "/api/logs/backup/abc123/stdout", "s3cr3t")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		b, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, "hello stdout", string(b))
+	})
+	t.Run("not found", func(t *testing.T) {
+		resp := get(t, "/api/logs/backup/missing/stdout", "s3cr3t")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("invalid stream", func(t *testing.T) {
+		resp := get(t, "/api/logs/backup/abc123/other", "s3cr3t")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			resp := get(t, "/api/logs/backup/abc123/stdout", token)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+}
+
+func TestHandlers_EmptyTokenDeniesAll(t *testing.T) {
+	a, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	a.RegisterHandlers(mux, "")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/api/logs/backup", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func setAge(t *testing.T, dir, job, runID string, age time.Duration) {
+	t.Helper()
+	ts := time.Now().Add(-age)
+	for _, stream := range streams {
+		path := filepath.Join(dir, job, runID+"."+stream+fileSuffix)
+		require.NoError(t, os.Chtimes(path, ts, ts))
+	}
+}
@@ -0,0 +1,108 @@
+package ringbuf
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// HeadTail retains the first head and the last tail bytes written to it and
+// keeps track of the data omitted in between. It implements io.Writer and is
+// not safe for concurrent use.
+type HeadTail struct {
+	head     []byte
+	headSize int
+	tail     *Buffer
+	total    int64
+	opts     options
+}
+
+// NewHeadTail returns a HeadTail that retains the first head and the last
+// tail bytes written to it. It panics if head < 0 or tail <= 0.
+func NewHeadTail(head, tail int, opts ...Option) *HeadTail {
+	if head < 0 {
+		panic(fmt.Sprintf("ringbuf: negative head size %d", head))
+	}
+
+	h := &HeadTail{
+		head:     make([]byte, 0, head),
+		headSize: head,
+		tail:     New(tail, opts...),
+	}
+	for _, opt := range opts {
+		opt(&h.opts)
+	}
+
+	return h
+}
+
+// Write appends p, filling the head first and passing the remainder to the
+// tail buffer. It always returns len(p), nil.
+func (h *HeadTail) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += int64(n)
+
+	if room := h.headSize - len(h.head); room > 0 {
+		k := min(room, len(p))
+		h.head = append(h.head, p[:k]...)
+		p = p[k:]
+	}
+	if len(p) > 0 {
+		_, _ = h.tail.Write(p)
+	}
+
+	return n, nil
+}
+
+// Total returns the number of bytes written in total.
+func (h *HeadTail) Total() int64 {
+	return h.total
+}
+
+// Omitted returns the number of bytes written between head and tail which are
+// no longer retained.
+func (h *HeadTail) Omitted() int64 {
+	return h.Discarded().Bytes
+}
+
+// Discarded returns the amount of data omitted between head and tail. In
+// line-aware mode this includes the partial lines at the end of the head and
+// the start of the tail.
+func (h *HeadTail) Discarded() Discarded {
+	head, tail := h.parts()
+	lines := int64(bytes.Count(h.head, []byte{'\n'})) + h.tail.lines
+
+	return Discarded{
+		Bytes: h.total - int64(len(head)) - int64(len(tail)),
+		Lines: lines - int64(bytes.Count(head, []byte{'\n'})) - int64(bytes.Count(tail, []byte{'\n'})),
+	}
+}
+
+// String returns the retained head and tail. If data was omitted in between,
+// a marker line stating its size separates the two parts.
+func (h *HeadTail) String() string {
+	head, tail := h.parts()
+
+	d := h.Discarded()
+	if d.Bytes == 0 {
+		return string(head) + string(tail)
+	}
+
+	marker := fmt.Sprintf("… %d bytes omitted …\n", d.Bytes)
+	if h.opts.lineAware {
+		marker = fmt.Sprintf("… %d bytes (%d lines) omitted …\n", d.Bytes, d.Lines)
+	}
+	if len(head) > 0 && head[len(head)-1] != '\n' {
+		marker = "\n" + marker
+	}
+
+	return string(head) + marker + string(tail)
+}
+
+func (h *HeadTail) parts() ([]byte, []byte) {
+	head, tail := h.head, h.tail.Bytes()
+	if h.opts.lineAware && h.tail.Discarded().Bytes > 0 {
+		head = trimLineEnd(head)
+	}
+
+	return head, tail
+}
@@ -0,0 +1,84 @@
+package ringbuf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ io.Writer = (*HeadTail)(nil)
+
+func TestNewHeadTail_PanicsOnInvalidSize(t *testing.T) {
+	assert.Panics(t, func() { NewHeadTail(-1, 8) })
+	assert.Panics(t, func() { NewHeadTail(8, 0) })
+}
+
+func TestHeadTail_Empty(t *testing.T) {
+	h := NewHeadTail(4, 4)
+	assert.Empty(t, h.String())
+	assert.Equal(t, int64(0), h.Omitted())
+	assert.Equal(t, int64(0), h.Total())
+}
+
+func TestHeadTail_FitsCompletely(t *testing.T) {
+	h := NewHeadTail(4, 4)
+	n, err := h.Write([]byte("abcdefgh"))
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "abcdefgh", h.String())
+	assert.Equal(t, int64(0), h.Omitted())
+}
+
+func TestHeadTail_OmitsMiddle(t *testing.T) {
+	h := NewHeadTail(4, 4)
+	for _, s := range []string{"ab", "cdef", "ghij", "kl"} {
+		_, _ = h.Write([]byte(s))
+	}
+	// "abcdefghijkl": head "abcd", tail "ijkl", omitted "efgh"
+	assert.Equal(t, int64(12), h.Total())
+	assert.Equal(t, int64(4), h.Omitted())
+	assert.Equal(t, "abcd\n… 4 bytes omitted …\nijkl", h.String())
+}
+
+func TestHeadTail_ZeroHeadBehavesLikeBuffer(t *testing.T) {
+	src := bytes.Repeat([]byte("0123456789"), 10)
+	h := NewHeadTail(0, 16)
+	_, err := io.Copy(h, bytes.NewReader(src))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(src)-16), h.Omitted())
+	assert.Equal(t, "… 84 bytes omitted …\n"+string(src[len(src)-16:]), h.String())
+}
+
+func TestHeadTail_LineAware(t *testing.T) {
+	h := NewHeadTail(12, 12, LineAware())
+	for i := range 10 {
+		_, _ = fmt.Fprintf(h, "line %d\n", i)
+	}
+	// head "line 0\nline" is cut back to "line 0\n", tail "e 8\nline 9\n" to "line 9\n"
+	assert.Equal(t, "line 0\n… 56 bytes (8 lines) omitted …\nline 9\n", h.String())
+	assert.Equal(t, Discarded{Bytes: 56, Lines: 8}, h.Discarded())
+	assert.Equal(t, int64(56), h.Omitted())
+}
+
+func TestHeadTail_LineAwareContiguous(t *testing.T) {
+	h := NewHeadTail(4, 16, LineAware())
+	_, _ = h.Write([]byte("abcdef\ngh"))
+	// nothing is omitted, so the head is not cut at a line boundary
+	assert.Equal(t, "abcdef\ngh", h.String())
+	assert.Equal(t, Discarded{}, h.Discarded())
+}
+
+func TestHeadTail_LineAwareRuneBoundaries(t *testing.T) {
+	h := NewHeadTail(3, 3, LineAware())
+	_, _ = h.Write([]byte("äöüßä"))
+	s := h.String()
+	assert.True(t, utf8.ValidString(s), s)
+	assert.True(t, strings.HasPrefix(s, "ä\n"), s)
+	assert.True(t, strings.HasSuffix(s, "ä"), s)
+}
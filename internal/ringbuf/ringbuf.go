@@ -1,27 +1,65 @@
 // Package ringbuf provides a fixed-size ring buffer that retains the last N
-// bytes written to it. It implements io.Writer and is intended for capturing
-// the tail of unbounded output streams (e.g. container logs) for display or
-// notification purposes.
+// bytes written to it, and a head-and-tail writer that additionally retains
+// the first K bytes. Both implement io.Writer and are intended for capturing
+// unbounded output streams (e.g. container logs) for display or notification
+// purposes.
 package ringbuf
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
 
-// Buffer retains the last cap(buf) bytes written to it. It is not safe for
+// Option configures a Buffer or HeadTail.
+type Option func(*options)
+
+type options struct {
+	lineAware bool
+}
+
+// LineAware makes the retained data start (and, for the head of a HeadTail,
+// end) at a line boundary once data was discarded, so no partial lines are
+// returned. If the retained data contains no line break, it is at least cut
+// at a UTF-8 rune boundary.
+func LineAware() Option {
+	return func(o *options) {
+		o.lineAware = true
+	}
+}
+
+// Discarded describes the data which was written but is no longer returned.
+type Discarded struct {
+	Bytes int64
+	Lines int64
+}
+
+// Buffer retains the last size bytes written to it. It is not safe for
 // concurrent use.
 type Buffer struct {
-	buf  []byte
-	pos  int
-	full bool
+	// buf holds one byte more than the configured size: the most recently
+	// discarded byte, which tells whether the retained data starts a line.
+	buf   []byte
+	pos   int
+	full  bool
+	total int64
+	lines int64
+	opts  options
 }
 
 // New returns a Buffer that retains the last size bytes written to it.
 // It panics if size <= 0.
-func New(size int) *Buffer {
+func New(size int, opts ...Option) *Buffer {
 	if size <= 0 {
 		panic(fmt.Sprintf("ringbuf: non-positive size %d", size))
 	}
 
-	return &Buffer{buf: make([]byte, size)}
+	b := &Buffer{buf: make([]byte, size+1)}
+	for _, opt := range opts {
+		opt(&b.opts)
+	}
+
+	return b
 }
 
 // Write appends p to the buffer, discarding the oldest bytes as needed to
@@ -31,6 +69,9 @@ func (b *Buffer) Write(p []byte) (int, error) {
 	if n == 0 {
 		return 0, nil
 	}
+	b.total += int64(n)
+	b.lines += int64(bytes.Count(p, []byte{'\n'}))
+
 	size := len(b.buf)
 	if n >= size {
 		copy(b.buf, p[n-size:])
@@ -55,6 +96,48 @@ func (b *Buffer) Write(p []byte) (int, error) {
 
 // Len returns the number of bytes currently buffered.
 func (b *Buffer) Len() int {
+	return min(b.rawLen(), b.size())
+}
+
+// Bytes returns a freshly-allocated slice containing the buffered bytes in
+// logical order (oldest first). In line-aware mode the partial first line is
+// omitted once data was discarded.
+func (b *Buffer) Bytes() []byte {
+	raw := b.raw()
+	if len(raw) <= b.size() {
+		return raw
+	}
+
+	// the first byte is the most recently discarded one
+	prev, data := raw[0], raw[1:]
+	if b.opts.lineAware && prev != '\n' {
+		data = trimLineStart(data)
+	}
+
+	return data
+}
+
+// String returns the buffered bytes as a string.
+func (b *Buffer) String() string {
+	return string(b.Bytes())
+}
+
+// Discarded returns the amount of written data which is not returned by
+// Bytes, including the partial first line in line-aware mode.
+func (b *Buffer) Discarded() Discarded {
+	data := b.Bytes()
+
+	return Discarded{
+		Bytes: b.total - int64(len(data)),
+		Lines: b.lines - int64(bytes.Count(data, []byte{'\n'})),
+	}
+}
+
+func (b *Buffer) size() int {
+	return len(b.buf) - 1
+}
+
+func (b *Buffer) rawLen() int {
 	if b.full {
 		return len(b.buf)
 	}
@@ -62,9 +145,9 @@ func (b *Buffer) Len() int {
 	return b.pos
 }
 
-// Bytes returns a freshly-allocated slice containing the buffered bytes in
-// logical order (oldest first).
-func (b *Buffer) Bytes() []byte {
+// raw returns all internally held bytes, including the most recently
+// discarded byte, in logical order.
+func (b *Buffer) raw() []byte {
 	if !b.full {
 		out := make([]byte, b.pos)
 		copy(out, b.buf[:b.pos])
@@ -79,7 +162,41 @@ func (b *Buffer) Bytes() []byte {
 	return out
 }
 
-// String returns the buffered bytes as a string.
-func (b *Buffer) String() string {
-	return string(b.Bytes())
+// trimLineStart drops the partial line at the start of data. Without any line
+// break, it drops incomplete UTF-8 sequence bytes instead.
+func trimLineStart(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i+1:]
+	}
+
+	for i := 0; i < len(data) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(data[i]) {
+			return data[i:]
+		}
+	}
+
+	return data
+}
+
+// trimLineEnd drops the partial line at the end of data. Without any line
+// break, it drops an incomplete trailing UTF-8 sequence instead.
+func trimLineEnd(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		return data[:i+1]
+	}
+
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return data
+			}
+
+			return data[:i]
+		}
+	}
+
+	return data
 }
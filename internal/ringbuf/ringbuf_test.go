@@ -2,8 +2,10 @@ package ringbuf
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -106,3 +108,52 @@ func TestBytesReturnsCopy(t *testing.T) {
 	out[0] = 'X'
 	assert.Equal(t, "hello", b.String())
 }
+
+func TestDiscarded(t *testing.T) {
+	b := New(8)
+	_, _ = b.Write([]byte("one\ntwo\nthree\n"))
+	// retained: "o\nthree\n"
+	assert.Equal(t, "o\nthree\n", b.String())
+	assert.Equal(t, Discarded{Bytes: 6, Lines: 1}, b.Discarded())
+}
+
+func TestLineAware_NoWrapKeepsEverything(t *testing.T) {
+	b := New(16, LineAware())
+	_, _ = b.Write([]byte("partial"))
+	assert.Equal(t, "partial", b.String())
+	assert.Equal(t, Discarded{}, b.Discarded())
+}
+
+func TestLineAware_DropsPartialFirstLine(t *testing.T) {
+	b := New(10, LineAware())
+	_, _ = b.Write([]byte("first line\nsecond\nthird\n"))
+	// raw tail "ond\nthird\n" starts mid-line
+	assert.Equal(t, "third\n", b.String())
+	assert.Equal(t, Discarded{Bytes: 18, Lines: 2}, b.Discarded())
+}
+
+func TestLineAware_KeepsLineStartingAtBoundary(t *testing.T) {
+	b := New(6, LineAware())
+	_, _ = b.Write([]byte("first\nthird\n"))
+	// the last discarded byte is a line break, so "third\n" is complete
+	assert.Equal(t, "third\n", b.String())
+	assert.Equal(t, Discarded{Bytes: 6, Lines: 1}, b.Discarded())
+}
+
+func TestLineAware_RuneBoundaryWithoutLineBreak(t *testing.T) {
+	b := New(5, LineAware())
+	_, _ = b.Write([]byte("aäöü")) // 'ä', 'ö', 'ü' are two bytes each
+	// raw tail is "\xa4öü": the orphaned continuation byte is dropped
+	assert.Equal(t, "öü", b.String())
+	assert.True(t, utf8.ValidString(b.String()))
+	assert.Equal(t, int64(3), b.Discarded().Bytes)
+}
+
+func TestLineAware_AcrossManyWrites(t *testing.T) {
+	b := New(12, LineAware())
+	for i := range 100 {
+		_, _ = fmt.Fprintf(b, "line %02d\n", i)
+	}
+	assert.Equal(t, "line 99\n", b.String())
+	assert.Equal(t, int64(99), b.Discarded().Lines)
+}
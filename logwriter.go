@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxLogLineLength caps the length of a single forwarded line, so that output
+// without any line breaks is still forwarded in bounded chunks.
+const maxLogLineLength = 16 * 1024
+
+// lineLogger is an io.Writer which forwards each complete line written to it
+// as a separate log entry. It is not safe for concurrent use.
+type lineLogger struct {
+	logger  *log.Entry
+	partial []byte
+}
+
+func newLineLogger(logger *log.Entry, stream string) *lineLogger {
+	return &lineLogger{logger: logger.WithField("stream", stream)}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.emit(l.partial[:i])
+		l.partial = l.partial[i+1:]
+	}
+
+	for len(l.partial) >= maxLogLineLength {
+		l.emit(l.partial[:maxLogLineLength])
+		l.partial = l.partial[maxLogLineLength:]
+	}
+
+	return len(p), nil
+}
+
+// Flush forwards a trailing line which was not terminated by a line break.
+func (l *lineLogger) Flush() {
+	if len(l.partial) > 0 {
+		l.emit(l.partial)
+		l.partial = nil
+	}
+}
+
+func (l *lineLogger) emit(line []byte) {
+	l.logger.Info(strings.TrimRight(string(line), "\r"))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineLogger_ForwardsCompleteLines(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	l := newLineLogger(logger.WithField("job", "backup"), "stderr")
+
+	n, err := l.Write([]byte("first\nsec"))
+	require.NoError(t, err)
+	require.Equal(t, 9, n)
+	_, _ = l.Write([]byte("ond\r\nthi"))
+	require.Len(t, hook.AllEntries(), 2)
+
+	l.Flush()
+	entries := hook.AllEntries()
+	require.Len(t, entries, 3)
+	require.Equal(t, "first", entries[0].Message)
+	require.Equal(t, "second", entries[1].Message)
+	require.Equal(t, "thi", entries[2].Message)
+	for _, e := range entries {
+		require.Equal(t, "backup", e.Data["job"])
+		require.Equal(t, "stderr", e.Data["stream"])
+		require.Equal(t, logrus.InfoLevel, e.Level)
+	}
+}
+
+func TestLineLogger_SplitsOverlongLines(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	l := newLineLogger(logrus.NewEntry(logger), "stdout")
+
+	_, _ = l.Write([]byte(strings.Repeat("x", maxLogLineLength+10)))
+	require.Len(t, hook.AllEntries(), 1)
+	require.Len(t, hook.LastEntry().Message, maxLogLineLength)
+
+	l.Flush()
+	require.Len(t, hook.AllEntries(), 2)
+	require.Len(t, hook.LastEntry().Message, 10)
+}
+
+func TestLineLogger_FlushWithoutPartialLine(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	l := newLineLogger(logrus.NewEntry(logger), "stdout")
+
+	_, _ = l.Write([]byte("done\n"))
+	l.Flush()
+	require.Len(t, hook.AllEntries(), 1)
+}
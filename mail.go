@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"net/mail"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hako/durafmt"
+	"github.com/0xERR0R/crony/internal/logarchive"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/gomail.v2"
 )
@@ -19,13 +24,17 @@ const (
 	Never MailPolicy = iota
 	Always
 	OnError
+	// OnChange notifies on the first failure after a success and on the
+	// first success after a failure.
+	OnChange
 )
 
 //nolint:gochecknoglobals // immutable lookup table
 var MailPolicyToString = map[MailPolicy]string{
-	Never:   "NEVER",
-	Always:  "ALWAYS",
-	OnError: "ONERROR",
+	Never:    "NEVER",
+	Always:   "ALWAYS",
+	OnError:  "ONERROR",
+	OnChange: "ONCHANGE",
 }
 
 func (m MailPolicy) String() string {
@@ -41,46 +50,251 @@ func (m *MailPolicy) Decode(value string) error {
 		}
 	}
 
-	return fmt.Errorf("unknown value '%s' for MailPolicy, please use one of 'never, always, onerror'", value)
+	return fmt.Errorf("unknown value '%s' for MailPolicy, please use one of 'never, always, onerror, onchange'", value)
 }
 
 type MailConfig struct {
-	SmtpHost     string     `envconfig:"smtp_host"     required:"true"`
-	SmtpPort     int        `envconfig:"smtp_port"     required:"true"`
-	SmtpUser     string     `envconfig:"smtp_user"`
-	SmtpPassword string     `envconfig:"smtp_password"`
-	MailTo       string     `envconfig:"mail_to"       required:"true"`
-	MailFrom     string     `envconfig:"mail_from"     required:"true"`
-	MailPolicy   MailPolicy `default:"never"           envconfig:"mail_policy"`
+	SmtpHost     string        `envconfig:"smtp_host"     required:"true"`
+	SmtpPort     int           `envconfig:"smtp_port"     required:"true"`
+	SmtpUser     string        `envconfig:"smtp_user"`
+	SmtpPassword string        `envconfig:"smtp_password" secret:"true"`
+	MailTo       string        `envconfig:"mail_to"       required:"true"`
+	MailCc       string        `envconfig:"mail_cc"`
+	MailBcc      string        `envconfig:"mail_bcc"`
+	MailFrom     string        `envconfig:"mail_from"     required:"true"`
+	MailPolicy   MailPolicy    `default:"never"           envconfig:"mail_policy"`
+	MailTimeout  time.Duration `default:"1m"              envconfig:"mail_timeout"`
+
+	SmtpTLSMode            string        `envconfig:"smtp_tls_mode"`
+	SmtpCAFile             string        `envconfig:"smtp_ca_file"`
+	SmtpInsecureSkipVerify bool          `default:"false" envconfig:"smtp_insecure_skip_verify"`
+	SmtpDialTimeout        time.Duration `default:"10s"   envconfig:"smtp_dial_timeout"`
+	SmtpSendTimeout        time.Duration `default:"1m"    envconfig:"smtp_send_timeout"`
+	SmtpPoolSize           int           `default:"2"     envconfig:"smtp_pool_size"`
+	SmtpIdleTimeout        time.Duration `default:"30s"   envconfig:"smtp_idle_timeout"`
+	SmtpSelfTest           bool          `default:"false" envconfig:"smtp_self_test"`
+
+	SmtpAuthMechanism     string `envconfig:"smtp_auth_mechanism"`
+	SmtpOAuthTokenURL     string `envconfig:"smtp_oauth_token_url"`
+	SmtpOAuthClientID     string `envconfig:"smtp_oauth_client_id"`
+	SmtpOAuthClientSecret string `envconfig:"smtp_oauth_client_secret" secret:"true"`
+	SmtpOAuthRefreshToken string `envconfig:"smtp_oauth_refresh_token" secret:"true"`
+	SmtpOAuthScopes       string `envconfig:"smtp_oauth_scopes"`
+
+	MailSpoolDir     string        `envconfig:"mail_spool_dir"`
+	MailRetryInitial time.Duration `default:"30s" envconfig:"mail_retry_initial"`
+	MailRetryMax     time.Duration `default:"1h"  envconfig:"mail_retry_max"`
+	MailQueueMaxAge  time.Duration `default:"24h" envconfig:"mail_queue_max_age"`
+
+	MailDkimDomain         string `envconfig:"mail_dkim_domain"`
+	MailDkimSelector       string `envconfig:"mail_dkim_selector"`
+	MailDkimPrivateKeyFile string `envconfig:"mail_dkim_private_key_file"`
+	MailDkimHeaders        string `envconfig:"mail_dkim_headers"`
+
+	MailSubjectTemplateFile string `envconfig:"mail_subject_template_file"`
+	MailBodyTemplateFile    string `envconfig:"mail_body_template_file"`
+
+	MailAttachOutput   bool `default:"false" envconfig:"mail_attach_output"`
+	MailAttachCompress bool `default:"false" envconfig:"mail_attach_compress"`
+	MailInlineLines    int  `default:"20"    envconfig:"mail_inline_lines"`
+
+	MailThrottleJobInterval  time.Duration `default:"0"  envconfig:"mail_throttle_job_interval"`
+	MailThrottleGlobalLimit  int           `default:"0"  envconfig:"mail_throttle_global_limit"`
+	MailThrottleGlobalWindow time.Duration `default:"1h" envconfig:"mail_throttle_global_window"`
+	MailDigestInterval       time.Duration `default:"0"  envconfig:"mail_digest_interval"`
+}
+
+// requiredMailEnv are the variables which enable mail notifications. They
+// must be set all together or not at all.
+//
+//nolint:gochecknoglobals // read-only list
+var requiredMailEnv = []string{"SMTP_HOST", "SMTP_PORT", "MAIL_TO", "MAIL_FROM"}
+
+// loadMailConfig parses and validates the global mail config. It returns nil
+// if mail notifications are not configured.
+func loadMailConfig() (*MailConfig, error) {
+	var missing []string
+	for _, name := range requiredMailEnv {
+		if lookupEnv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == len(requiredMailEnv) {
+		return nil, nil //nolint:nilnil // mail is optional
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("incomplete mail config, %s must be set as well", strings.Join(missing, ", "))
+	}
+
+	var cfg MailConfig
+	if err := processConfig(&cfg); err != nil {
+		return nil, fmt.Errorf("can't parse mail config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mail config: %w", err)
+	}
+	if _, err := loadMailTemplates(cfg.MailSubjectTemplateFile, cfg.MailBodyTemplateFile); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
 }
 
 func (mc *MailConfig) Validate() error {
+	if err := mc.validateAuth(); err != nil {
+		return err
+	}
+
+	if mc.MailTimeout < 0 {
+		return errors.New("MAIL_TIMEOUT must not be negative")
+	}
+
+	if mc.MailThrottleJobInterval < 0 || mc.MailThrottleGlobalLimit < 0 || mc.MailDigestInterval < 0 {
+		return errors.New("MAIL_THROTTLE_* and MAIL_DIGEST_INTERVAL must not be negative")
+	}
+
+	switch strings.ToLower(mc.SmtpTLSMode) {
+	case "", smtpTLSModeNone, smtpTLSModeStartTLS, smtpTLSModeTLS:
+	default:
+		return fmt.Errorf("unknown value '%s' for SMTP_TLS_MODE, please use one of 'none, starttls, tls'",
+			mc.SmtpTLSMode)
+	}
+
+	if mc.MailRetryInitial < 0 || mc.MailRetryMax < 0 || mc.MailQueueMaxAge < 0 {
+		return errors.New("MAIL_RETRY_INITIAL, MAIL_RETRY_MAX and MAIL_QUEUE_MAX_AGE must not be negative")
+	}
+
+	if mc.SmtpPoolSize < 0 {
+		return errors.New("SMTP_POOL_SIZE must not be negative")
+	}
+
+	if mc.MailDkimPrivateKeyFile != "" && (mc.MailDkimDomain == "" || mc.MailDkimSelector == "") {
+		return errors.New("MAIL_DKIM_DOMAIN and MAIL_DKIM_SELECTOR are required for DKIM signing")
+	}
+
+	if mc.MailInlineLines < 0 {
+		return errors.New("MAIL_INLINE_LINES must not be negative")
+	}
+
+	if mc.MailThrottleGlobalLimit > 0 && mc.MailThrottleGlobalWindow <= 0 {
+		return errors.New("MAIL_THROTTLE_GLOBAL_WINDOW must be positive")
+	}
+
+	return mc.validateAddresses()
+}
+
+// validateAuth checks the credentials required by the auth mechanism.
+func (mc *MailConfig) validateAuth() error {
+	switch strings.ToLower(mc.SmtpAuthMechanism) {
+	case smtpAuthXOAuth2:
+		if mc.SmtpUser == "" || mc.SmtpOAuthTokenURL == "" || mc.SmtpOAuthClientID == "" {
+			return errors.New("SMTP_USER, SMTP_OAUTH_TOKEN_URL and SMTP_OAUTH_CLIENT_ID are required for XOAUTH2")
+		}
+
+		return nil
+	case "", smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5:
+	default:
+		return fmt.Errorf(
+			"unknown value '%s' for SMTP_AUTH_MECHANISM, please use one of 'plain, login, cram-md5, xoauth2'",
+			mc.SmtpAuthMechanism)
+	}
+
 	if (mc.SmtpUser != "" && mc.SmtpPassword == "") || (mc.SmtpUser == "" && mc.SmtpPassword != "") {
 		return errors.New("SMTP_USER and SMTP_PASSWORD must be provided together, or not at all")
 	}
+	if mc.SmtpAuthMechanism != "" && mc.SmtpUser == "" {
+		return errors.New("SMTP_USER and SMTP_PASSWORD are required for SMTP_AUTH_MECHANISM")
+	}
 
 	return nil
 }
 
-func (m MailConfig) String() string {
-	return fmt.Sprintf(
-		"mail config [host=%s, port=%d, user=%s, mailTo=%s, mailFrom=%s, mailPolicy=%s]",
-		m.SmtpHost, m.SmtpPort, m.SmtpUser, m.MailTo, m.MailFrom, m.MailPolicy,
-	)
+// validateAddresses checks the syntax of all non-empty sender and recipient
+// addresses.
+func (mc *MailConfig) validateAddresses() error {
+	if mc.MailFrom != "" {
+		if _, err := mail.ParseAddress(mc.MailFrom); err != nil {
+			return fmt.Errorf("invalid sender address '%s': %w", mc.MailFrom, err)
+		}
+	}
+
+	for _, recipients := range []string{mc.MailTo, mc.MailCc, mc.MailBcc} {
+		if _, err := parseAddressList(recipients); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-type MailParams struct {
-	ContainerName string
-	ReturnCode    int64
-	Duration      time.Duration
-	StdOut        string
-	StdErr        string
+// forContainer applies the container's label overrides and validates the
+// addresses.
+func (mc MailConfig) forContainer(container CronyContainer) (*MailConfig, error) {
+	overrides := map[string]*string{
+		mailToLabel:   &mc.MailTo,
+		mailCcLabel:   &mc.MailCc,
+		mailBccLabel:  &mc.MailBcc,
+		mailFromLabel: &mc.MailFrom,
+
+		mailSubjectTemplateFileLabel: &mc.MailSubjectTemplateFile,
+		mailBodyTemplateFileLabel:    &mc.MailBodyTemplateFile,
+	}
+	for label, field := range overrides {
+		if v, ok := container.Labels[label]; ok {
+			*field = v
+		}
+	}
+
+	if v, ok := container.Labels[mailAttachOutputLabel]; ok {
+		attach, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse '%s' label: %w", mailAttachOutputLabel, err)
+		}
+		mc.MailAttachOutput = attach
+	}
+
+	if strings.TrimSpace(mc.MailTo) == "" {
+		return nil, fmt.Errorf("'%s' label must not be empty", mailToLabel)
+	}
+	if err := mc.validateAddresses(); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
 }
 
-func (mp MailParams) ShortDuration() string {
-	return durafmt.Parse(mp.Duration.Truncate(time.Second)).String()
+// parseAddressList parses a comma separated list of addresses and returns
+// them formatted for mail headers.
+func parseAddressList(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address list '%s': %w", value, err)
+	}
+
+	addresses := make([]string, 0, len(list))
+	for _, a := range list {
+		addresses = append(addresses, a.String())
+	}
+
+	return addresses, nil
+}
+
+func (m MailConfig) String() string {
+	return fmt.Sprintf(
+		"mail config [host=%s, port=%d, tlsMode=%s, auth=%s, user=%s, "+
+			"mailTo=%s, mailCc=%s, mailBcc=%s, mailFrom=%s, mailPolicy=%s]",
+		m.SmtpHost, m.SmtpPort, m.SmtpTLSMode, m.SmtpAuthMechanism, m.SmtpUser,
+		m.MailTo, m.MailCc, m.MailBcc, m.MailFrom, m.MailPolicy,
+	)
 }
 
+// MailParams is the data model exposed to the mail templates.
+type MailParams = RunResult
+
 func newTemplate() *template.Template {
 	//nolint:staticcheck // ST1018: unicode glyphs in template body are intentional
 	return template.Must(template.New("mail-body").Parse(`
@@ -88,35 +302,249 @@ func newTemplate() *template.Template {
 			📦 Container: ​<b>{{.ContainerName}}</b>,
 			Execution: return code 🗠<b>{{.ReturnCode}}</b> in ​⏱️ <b>{{.ShortDuration}}</b>​,
 		</p>
+		{{if .OutputFailure}}<p>⚠️ <b>{{.OutputFailure}}</b></p>{{end}}
+		{{if .OutputSuccess}}<p>✔️ <b>{{.OutputSuccess}}</b></p>{{end}}
+		{{- if .Output}}
+			📝 output: ​<pre>{{.Output}}</pre>​
+		{{- else}}
 			📝 stdOut: ​<pre>{{.StdOut}}</pre>​
 			📝 stdErr: ​<pre style="color: #a13d3d">{{.StdErr}}</pre>​
+		{{- end}}
   `))
 }
 
 func createTopic(params MailParams) string {
-	if params.ReturnCode == 0 {
+	if params.Recovered() {
+		return fmt.Sprintf("[RECOVERED] ✔️ '%s' recovered after %d failed runs, finished in %s",
+			params.ContainerName, params.FailedRuns, params.ShortDuration())
+	}
+
+	if params.OutputSuccess != "" && params.Success() {
+		return fmt.Sprintf("[SUCCESS] ✔️ '%s' finished in %s: %s",
+			params.ContainerName, params.ShortDuration(), params.OutputSuccess)
+	}
+
+	if params.Success() {
 		return fmt.Sprintf("[SUCCESS] ✔️ '%s' finished in %s", params.ContainerName, params.ShortDuration())
 	}
 
+	if params.OutputFailure != "" {
+		return fmt.Sprintf("[FAIL] ❌ '%s' failed in %s: %s",
+			params.ContainerName, params.ShortDuration(), params.OutputFailure)
+	}
+
 	return fmt.Sprintf("[FAIL] ❌ '%s' failed in %s", params.ContainerName, params.ShortDuration())
 }
 
-func SendMail(config *MailConfig, params MailParams) error {
-	msg := gomail.NewMessage()
-	msg.SetHeader("From", config.MailFrom)
-	msg.SetHeader("To", config.MailTo)
-	msg.SetHeader("Subject", createTopic(params))
+func createDigestTopic(params DigestParams) string {
+	if params.Failed > 0 {
+		return fmt.Sprintf("[DIGEST] ❌ %d job runs, %d failed", len(params.Results), params.Failed)
+	}
+
+	return fmt.Sprintf("[DIGEST] ✔️ %d job runs, all successful", len(params.Results))
+}
+
+func SendMail(ctx context.Context, transport mailTransport, config *MailConfig, templates *mailTemplates,
+	archive *logarchive.Archive, params MailParams,
+) error {
+	var attachments []mailAttachment
+	if config.MailAttachOutput {
+		attachments = outputAttachments(params, config.MailAttachCompress, archive)
+		params = inlineExcerpt(params, config.MailInlineLines)
+	}
+
+	return sendHTMLMail(ctx, transport, config, templates.subjectFor(params), templates.bodyFor(params), attachments...)
+}
+
+// SendDigestMail sends a summary of the results as a single mail.
+func SendDigestMail(ctx context.Context, transport mailTransport, config *MailConfig, results []RunResult) error {
+	params := newDigestParams(results)
 	buf := bytes.NewBuffer(nil)
-	err := newTemplate().Execute(buf, params)
+	if err := newDigestTemplate().Execute(buf, params); err != nil {
+		return fmt.Errorf("can't render digest: %w", err)
+	}
+
+	return sendHTMLMail(ctx, transport, config, createDigestTopic(params), buf.String())
+}
+
+// mailAttachment is a file attached to a mail.
+type mailAttachment struct {
+	name        string
+	contentType string
+	content     []byte
+}
+
+// outputAttachments returns stdout and stderr as attachments, optionally gzip
+// compressed. If the output is archived, the complete output is read from the
+// archive, otherwise the captured output is attached.
+func outputAttachments(params MailParams, compress bool, archive *logarchive.Archive) []mailAttachment {
+	var attachments []mailAttachment
+	streams := []struct{ name, output string }{
+		{logarchive.Stdout, params.StdOut},
+		{logarchive.Stderr, params.StdErr},
+	}
+	for _, stream := range streams {
+		if stream.output == "" {
+			continue
+		}
+
+		a := mailAttachment{
+			name:        fmt.Sprintf("%s-%s.log", params.ContainerName, stream.name),
+			contentType: "text/plain; charset=utf-8",
+			content:     []byte(stream.output),
+		}
+		compressed := false
+		if archive != nil {
+			content, err := archivedOutput(archive, params, stream.name, compress)
+			if err != nil {
+				log.WithError(err).WithField("job", params.ContainerName).
+					Warn("can't read archived output, attaching the captured output")
+			} else {
+				a.content, compressed = content, compress
+			}
+		}
+		if compress {
+			if !compressed {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				_, _ = zw.Write(a.content)
+				_ = zw.Close()
+				a.content = buf.Bytes()
+			}
+			a.name += ".gz"
+			a.contentType = "application/gzip"
+		}
+		attachments = append(attachments, a)
+	}
+
+	return attachments
+}
+
+// archivedOutput reads a stream of the run from the archive, where it is
+// stored gzip compressed.
+func archivedOutput(archive *logarchive.Archive, params MailParams, stream string, compressed bool) ([]byte, error) {
+	f, err := archive.Open(params.ContainerName, params.RunID, stream)
 	if err != nil {
-		log.Error("error during template processing", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	if compressed {
+		return io.ReadAll(f)
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(zr)
+}
+
+// inlineExcerpt shortens the output in params to its last lines, the full
+// output is attached.
+func inlineExcerpt(params MailParams, lines int) MailParams {
+	excerpt := func(s string) string {
+		if strings.Count(strings.TrimRight(s, "\n"), "\n") < lines {
+			return s
+		}
+
+		return "[…]\n" + tailLines(s, lines, len(s))
 	}
-	msg.SetBody("text/html", buf.String())
 
-	d := gomail.NewDialer(config.SmtpHost, config.SmtpPort, config.SmtpUser, config.SmtpPassword)
-	if err := d.DialAndSend(msg); err != nil {
+	params.StdOut = excerpt(params.StdOut)
+	params.StdErr = excerpt(params.StdErr)
+	params.Output = excerpt(params.Output)
+
+	return params
+}
+
+func sendHTMLMail(ctx context.Context, transport mailTransport, config *MailConfig, subject, body string,
+	attachments ...mailAttachment,
+) error {
+	msg, err := newMailMessage(config, subject, body, attachments...)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	from, err := mail.ParseAddress(config.MailFrom)
+	if err != nil {
+		return fmt.Errorf("invalid sender address '%s': %w", config.MailFrom, err)
+	}
+
+	var to []string
+	for _, recipients := range []string{config.MailTo, config.MailCc, config.MailBcc} {
+		list, err := parseAddressList(recipients)
+		if err != nil {
+			return err
+		}
+		for _, a := range list {
+			// the formatted address is valid, as it was parsed before
+			addr, _ := mail.ParseAddress(a)
+			to = append(to, addr.Address)
+		}
+	}
+
+	return transport.Send(ctx, from.Address, to, msg)
+}
+
+// newMailMessage creates a multipart/alternative mail with a plain text part
+// generated from the HTML body.
+func newMailMessage(config *MailConfig, subject, body string, attachments ...mailAttachment) (*gomail.Message, error) {
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", config.MailFrom)
+	for header, value := range map[string]string{"To": config.MailTo, "Cc": config.MailCc, "Bcc": config.MailBcc} {
+		addresses, err := parseAddressList(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(addresses) > 0 {
+			msg.SetHeader(header, addresses...)
+		}
+	}
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", htmlToText(body))
+	msg.AddAlternative("text/html", body)
+
+	for _, a := range attachments {
+		msg.Attach(a.name,
+			gomail.SetHeader(map[string][]string{"Content-Type": {a.contentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(a.content)
+
+				return err
+			}),
+		)
+	}
+
+	return msg, nil
+}
+
+// mailNotifier sends the result of a job run as mail. If digest is set,
+// results are collected instead and sent as summary.
+type mailNotifier struct {
+	config    *MailConfig
+	transport mailTransport
+	templates *mailTemplates
+	throttle  *throttle
+	digest    *digest
+	archive   *logarchive.Archive
+}
+
+func (n *mailNotifier) Name() string {
+	return "mail"
+}
+
+func (n *mailNotifier) Notify(ctx context.Context, result RunResult) error {
+	if n.digest != nil {
+		n.digest.add(n.config, result)
+
+		return nil
+	}
+
+	if !n.throttle.allow(result.ContainerName) {
+		return errThrottled
+	}
+
+	return SendMail(ctx, n.transport, n.config, n.templates, n.archive, result)
 }
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/0xERR0R/crony/internal/logarchive"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,6 +17,7 @@ func TestMailPolicy_StringRoundTrip(t *testing.T) {
 	require.Equal(t, "NEVER", Never.String())
 	require.Equal(t, "ALWAYS", Always.String())
 	require.Equal(t, "ONERROR", OnError.String())
+	require.Equal(t, "ONCHANGE", OnChange.String())
 }
 
 func TestMailPolicy_Decode(t *testing.T) {
@@ -29,6 +34,8 @@ func TestMailPolicy_Decode(t *testing.T) {
 		{"onerror", OnError, false},
 		{"ONERROR", OnError, false},
 		{"OnError", OnError, false},
+		{"onchange", OnChange, false},
+		{"OnChange", OnChange, false},
 		{"bogus", 0, true},
 		{"", 0, true},
 	}
@@ -71,6 +78,71 @@ func TestMailConfig_Validate(t *testing.T) {
 	})
 }
 
+func TestMailConfig_ValidateThrottle(t *testing.T) {
+	require.Error(t, (&MailConfig{MailThrottleJobInterval: -time.Second}).Validate())
+	require.Error(t, (&MailConfig{MailDigestInterval: -time.Second}).Validate())
+	require.ErrorContains(t, (&MailConfig{MailTimeout: -time.Second}).Validate(), "MAIL_TIMEOUT")
+	require.Error(t, (&MailConfig{MailThrottleGlobalLimit: 5}).Validate())
+	require.NoError(t, (&MailConfig{MailThrottleGlobalLimit: 5, MailThrottleGlobalWindow: time.Hour}).Validate())
+}
+
+func TestMailNotifier_ThrottleAndDigest(t *testing.T) {
+	thr := newThrottle(time.Hour, 0, 0)
+	thr.lastSent["backup"] = time.Now()
+	n := &mailNotifier{config: &MailConfig{}, throttle: thr}
+	require.ErrorIs(t, n.Notify(context.Background(), MailParams{ContainerName: "backup"}), errThrottled)
+
+	rec := &digestRecorder{}
+	n = &mailNotifier{config: &MailConfig{}, throttle: thr, digest: newDigest(time.Hour, rec.send)}
+	require.NoError(t, n.Notify(context.Background(), MailParams{ContainerName: "backup"}))
+	n.digest.flush()
+	require.Equal(t, 1, rec.count(), "digest collects results, unaffected by the throttle")
+}
+
+func TestMailConfig_ValidateAddresses(t *testing.T) {
+	require.NoError(t, (&MailConfig{MailTo: "a@example.com, B <b@example.com>", MailFrom: "crony@example.com"}).Validate())
+	require.ErrorContains(t, (&MailConfig{MailTo: "not an address"}).Validate(), "invalid address list")
+	require.ErrorContains(t, (&MailConfig{MailFrom: "a@example.com, b@example.com"}).Validate(), "sender")
+	require.Error(t, (&MailConfig{MailBcc: "a@"}).Validate())
+}
+
+func TestMailConfig_ForContainer(t *testing.T) {
+	global := MailConfig{MailTo: "ops@example.com", MailCc: "cc@example.com", MailFrom: "crony@example.com"}
+
+	cfg, err := global.forContainer(CronyContainer{})
+	require.NoError(t, err)
+	require.Equal(t, global, *cfg)
+
+	cfg, err = global.forContainer(CronyContainer{Labels: map[string]string{
+		mailToLabel:   "team-a@example.com,team-b@example.com",
+		mailCcLabel:   "",
+		mailBccLabel:  "audit@example.com",
+		mailFromLabel: "Backups <backup@example.com>",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "team-a@example.com,team-b@example.com", cfg.MailTo)
+	require.Empty(t, cfg.MailCc)
+	require.Equal(t, "audit@example.com", cfg.MailBcc)
+	require.Equal(t, "Backups <backup@example.com>", cfg.MailFrom)
+	require.Equal(t, "ops@example.com", global.MailTo, "global config is unchanged")
+
+	_, err = global.forContainer(CronyContainer{Labels: map[string]string{mailToLabel: "team-a"}})
+	require.Error(t, err)
+
+	_, err = global.forContainer(CronyContainer{Labels: map[string]string{mailToLabel: " "}})
+	require.ErrorContains(t, err, mailToLabel)
+}
+
+func TestParseAddressList(t *testing.T) {
+	addresses, err := parseAddressList("")
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+
+	addresses, err = parseAddressList("a@example.com, Team B <b@example.com>")
+	require.NoError(t, err)
+	require.Equal(t, []string{"<a@example.com>", `"Team B" <b@example.com>`}, addresses)
+}
+
 func TestCreateTopic(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		topic := createTopic(MailParams{
@@ -92,6 +164,38 @@ func TestCreateTopic(t *testing.T) {
 		require.Contains(t, topic, "backup")
 		require.Contains(t, topic, "1 minute 30 seconds")
 	})
+	t.Run("output failure", func(t *testing.T) {
+		topic := createTopic(MailParams{
+			ContainerName: "backup",
+			ReturnCode:    0,
+			Duration:      2 * time.Second,
+			OutputFailure: "output matched: ERROR: disk full",
+		})
+		require.True(t, strings.HasPrefix(topic, "[FAIL]"))
+		require.Contains(t, topic, "ERROR: disk full")
+	})
+	t.Run("output success", func(t *testing.T) {
+		topic := createTopic(MailParams{
+			ContainerName: "backup",
+			ReturnCode:    1,
+			Duration:      2 * time.Second,
+			OutputSuccess: "output matched: backup complete",
+		})
+		require.True(t, strings.HasPrefix(topic, "[SUCCESS]"))
+		require.Contains(t, topic, "backup complete")
+	})
+}
+
+func TestMailParams_Success(t *testing.T) {
+	require.True(t, MailParams{}.Success())
+	require.False(t, MailParams{ReturnCode: 1}.Success())
+	require.False(t, MailParams{OutputFailure: "output matched: ERROR"}.Success())
+	require.True(t, MailParams{ReturnCode: 1, OutputSuccess: "output matched: complete"}.Success())
+}
+
+func TestMailParams_Outcome(t *testing.T) {
+	require.Equal(t, "success", MailParams{}.Outcome())
+	require.Equal(t, "failure", MailParams{ReturnCode: 1}.Outcome())
 }
 
 func TestMailParams_ShortDuration(t *testing.T) {
@@ -129,3 +233,116 @@ func TestNewTemplate_Renders(t *testing.T) {
 	require.Contains(t, rendered, "boom stderr")
 	require.Contains(t, rendered, "3 seconds")
 }
+
+func TestNewTemplate_RendersCombinedOutput(t *testing.T) {
+	var buf bytes.Buffer
+	err := newTemplate().Execute(&buf, MailParams{
+		ContainerName: "backup",
+		StdOut:        "hello stdout",
+		StdErr:        "boom stderr",
+		Output:        "2026-10-19T03:00:00.000Z stdout | hello stdout\n2026-10-19T03:00:01.000Z stderr | boom stderr\n",
+	})
+	require.NoError(t, err)
+	rendered := buf.String()
+	require.Contains(t, rendered, "stdout | hello stdout")
+	require.Contains(t, rendered, "stderr | boom stderr")
+	require.NotContains(t, rendered, "stdErr:")
+}
+
+func TestCreateTopic_Recovered(t *testing.T) {
+	topic := createTopic(MailParams{ContainerName: "backup", FailedRuns: 3, Duration: 2 * time.Second})
+	require.Equal(t, "[RECOVERED] ✔️ 'backup' recovered after 3 failed runs, finished in 2 seconds", topic)
+}
+
+func TestNewMailMessage_MultipartAlternative(t *testing.T) {
+	cfg := &MailConfig{MailFrom: "crony@example.com", MailTo: "a@example.com", MailBcc: "hidden@example.com"}
+	msg, err := newMailMessage(cfg, "subject", "<p>Hello <b>world</b></p>")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = msg.WriteTo(&buf)
+	require.NoError(t, err)
+
+	raw := buf.String()
+	require.Contains(t, raw, "Content-Type: multipart/alternative")
+	require.Contains(t, raw, "Content-Type: text/plain; charset=UTF-8")
+	require.Contains(t, raw, "Hello world")
+	require.Contains(t, raw, "Content-Type: text/html; charset=UTF-8")
+	require.NotContains(t, raw, "hidden@example.com", "bcc isn't part of the headers")
+}
+
+func TestNewMailMessage_Attachments(t *testing.T) {
+	cfg := &MailConfig{MailFrom: "crony@example.com", MailTo: "a@example.com"}
+	attachments := outputAttachments(MailParams{ContainerName: "backup", StdOut: "out", StdErr: "err"}, false, nil)
+	msg, err := newMailMessage(cfg, "subject", "<p>body</p>", attachments...)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = msg.WriteTo(&buf)
+	require.NoError(t, err)
+
+	raw := buf.String()
+	require.Contains(t, raw, "Content-Type: multipart/mixed")
+	require.Contains(t, raw, `filename="backup-stdout.log"`)
+	require.Contains(t, raw, `filename="backup-stderr.log"`)
+}
+
+func TestOutputAttachments(t *testing.T) {
+	require.Empty(t, outputAttachments(MailParams{}, false, nil))
+
+	attachments := outputAttachments(MailParams{ContainerName: "backup", StdErr: "boom\n"}, true, nil)
+	require.Len(t, attachments, 1)
+	require.Equal(t, "backup-stderr.log.gz", attachments[0].name)
+	require.Equal(t, "application/gzip", attachments[0].contentType)
+
+	zr, err := gzip.NewReader(bytes.NewReader(attachments[0].content))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, "boom\n", string(content))
+}
+
+func TestOutputAttachments_FromArchive(t *testing.T) {
+	archive, err := logarchive.New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	run, err := archive.Create("backup", "abc123")
+	require.NoError(t, err)
+	_, _ = io.WriteString(run.Stdout(), "complete stdout\n")
+	require.NoError(t, run.Close())
+
+	params := MailParams{ContainerName: "backup", RunID: "abc123", StdOut: "… omitted …\n", StdErr: "captured"}
+
+	attachments := outputAttachments(params, false, archive)
+	require.Len(t, attachments, 2)
+	require.Equal(t, "complete stdout\n", string(attachments[0].content))
+	require.Empty(t, attachments[1].content, "archived stderr is empty")
+
+	attachments = outputAttachments(params, true, archive)
+	zr, err := gzip.NewReader(bytes.NewReader(attachments[0].content))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, "complete stdout\n", string(content))
+
+	params.RunID = "missing"
+	attachments = outputAttachments(params, false, archive)
+	require.Equal(t, "… omitted …\n", string(attachments[0].content), "falls back to the captured output")
+}
+
+func TestInlineExcerpt(t *testing.T) {
+	params := inlineExcerpt(MailParams{StdOut: "1\n2\n3\n4\n", StdErr: "a\nb\n", Output: "x"}, 2)
+	require.Equal(t, "[…]\n3\n4", params.StdOut)
+	require.Equal(t, "a\nb\n", params.StdErr, "short output is kept")
+	require.Equal(t, "x", params.Output)
+}
+
+func TestMailConfig_ForContainerAttachOutput(t *testing.T) {
+	global := MailConfig{MailTo: "ops@example.com"}
+
+	cfg, err := global.forContainer(CronyContainer{Labels: map[string]string{mailAttachOutputLabel: "true"}})
+	require.NoError(t, err)
+	require.True(t, cfg.MailAttachOutput)
+
+	_, err = global.forContainer(CronyContainer{Labels: map[string]string{mailAttachOutputLabel: "maybe"}})
+	require.ErrorContains(t, err, mailAttachOutputLabel)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolFileExt = ".json"
+	// mailQueueTick is the maximum time between two checks of the queue
+	mailQueueTick = 15 * time.Second
+)
+
+//nolint:gochecknoglobals // prometheus metrics are conventionally package-level
+var (
+	mailQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "crony_mail_queue_length",
+		Help: "Number of undelivered mails in the queue",
+	})
+
+	mailQueueOldestAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "crony_mail_queue_oldest_age_sec",
+		Help: "Age of the oldest undelivered mail in the queue",
+	})
+
+	mailDeliveryFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "crony_mail_delivery_failure_count",
+		Help: "Number of failed mail delivery attempts",
+	})
+)
+
+// mailTransport delivers composed mails.
+type mailTransport interface {
+	Send(ctx context.Context, from string, to []string, msg io.WriterTo) error
+}
+
+// queuedMail is a mail waiting for delivery. It is stored as JSON in the
+// spool directory.
+type queuedMail struct {
+	ID          string    `json:"id"`
+	From        string    `json:"from"`
+	To          []string  `json:"to"`
+	Message     []byte    `json:"message"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// mailQueue delivers mails asynchronously and retries failed deliveries with
+// exponential backoff. If dir is set, pending mails are spooled to disk, so
+// they survive restarts.
+type mailQueue struct {
+	transport mailTransport
+	// workers is the number of concurrent deliveries, so the connections of
+	// the SMTP pool are used in parallel
+	workers      int
+	dir          string
+	timeout      time.Duration
+	retryInitial time.Duration
+	retryMax     time.Duration
+	maxAge       time.Duration
+	now          func() time.Time
+
+	mu      sync.Mutex
+	pending []*queuedMail
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMailQueue(transport mailTransport, config *MailConfig) (*mailQueue, error) {
+	q := &mailQueue{
+		transport:    transport,
+		workers:      max(config.SmtpPoolSize, 1),
+		dir:          config.MailSpoolDir,
+		timeout:      config.MailTimeout,
+		retryInitial: config.MailRetryInitial,
+		retryMax:     config.MailRetryMax,
+		maxAge:       config.MailQueueMaxAge,
+		now:          time.Now,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	if q.dir != "" {
+		if err := os.MkdirAll(q.dir, 0o750); err != nil {
+			return nil, fmt.Errorf("can't create mail spool directory: %w", err)
+		}
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+	q.updateMetrics()
+
+	return q, nil
+}
+
+// load reads the spooled mails. Unreadable files are logged and skipped.
+func (q *mailQueue) load() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("can't read mail spool directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolFileExt) {
+			continue
+		}
+
+		path := filepath.Join(q.dir, e.Name())
+		b, err := os.ReadFile(path)
+		var m queuedMail
+		if err == nil {
+			err = json.Unmarshal(b, &m)
+		}
+		if err != nil {
+			log.WithField("file", path).WithError(err).Error("can't load spooled mail")
+
+			continue
+		}
+		q.pending = append(q.pending, &m)
+	}
+
+	slices.SortFunc(q.pending, func(a, b *queuedMail) int { return a.Created.Compare(b.Created) })
+	if len(q.pending) > 0 {
+		log.WithField("mails", len(q.pending)).Info("loaded undelivered mails from spool")
+	}
+
+	return nil
+}
+
+// Send queues the mail for delivery.
+func (q *mailQueue) Send(_ context.Context, from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return fmt.Errorf("can't compose mail: %w", err)
+	}
+
+	now := q.now()
+	m := &queuedMail{
+		ID:          strconv.FormatInt(now.UnixNano(), 10) + "-" + newRunID(),
+		From:        from,
+		To:          to,
+		Message:     buf.Bytes(),
+		Created:     now,
+		NextAttempt: now,
+	}
+	if err := q.save(m); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.pending = append(q.pending, m)
+	q.mu.Unlock()
+	q.updateMetrics()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// start delivers queued mails in the background until Stop is called.
+func (q *mailQueue) start() {
+	go func() {
+		defer close(q.done)
+
+		for {
+			q.deliverDue()
+
+			timer := time.NewTimer(q.untilNextAttempt())
+			select {
+			case <-q.wake:
+			case <-timer.C:
+			case <-q.stop:
+				timer.Stop()
+				// last chance for mails queued during the shutdown
+				q.deliverDue()
+
+				return
+			}
+			timer.Stop()
+		}
+	}()
+}
+
+// Stop stops the delivery. Undelivered mails stay in the spool directory.
+func (q *mailQueue) Stop() {
+	close(q.stop)
+	<-q.done
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) > 0 && q.dir == "" {
+		log.WithField("mails", len(q.pending)).Warn("undelivered mails are lost, set MAIL_SPOOL_DIR to keep them")
+	}
+}
+
+func (q *mailQueue) untilNextAttempt() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := mailQueueTick
+	for _, m := range q.pending {
+		wait = min(wait, m.NextAttempt.Sub(q.now()))
+	}
+
+	return max(wait, 0)
+}
+
+// deliverDue tries to deliver all mails whose next attempt is due, up to
+// workers at a time, and waits until they are done.
+func (q *mailQueue) deliverDue() {
+	q.mu.Lock()
+	var due []*queuedMail
+	for _, m := range q.pending {
+		if !m.NextAttempt.After(q.now()) {
+			due = append(due, m)
+		}
+	}
+	q.mu.Unlock()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, q.workers)
+	for _, m := range due {
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			q.deliver(m)
+		})
+	}
+	wg.Wait()
+	q.updateMetrics()
+}
+
+func (q *mailQueue) deliver(m *queuedMail) {
+	logger := log.WithFields(log.Fields{"mail_id": m.ID, "attempt": m.Attempts + 1})
+
+	ctx, cancel := withTimeout(context.Background(), q.timeout)
+	err := q.transport.Send(ctx, m.From, m.To, bytes.NewReader(m.Message))
+	cancel()
+
+	if err == nil {
+		logger.Debug("mail delivered")
+		q.remove(m)
+
+		return
+	}
+
+	mailDeliveryFailures.Inc()
+	q.mu.Lock()
+	m.Attempts++
+	q.mu.Unlock()
+
+	if q.maxAge > 0 && q.now().Sub(m.Created) >= q.maxAge {
+		logger.WithError(err).Error("can't send mail, giving up")
+		q.remove(m)
+
+		return
+	}
+
+	backoff := time.Duration(float64(q.retryInitial) * math.Pow(2, float64(m.Attempts-1)))
+	if backoff > q.retryMax || backoff <= 0 {
+		backoff = q.retryMax
+	}
+	q.mu.Lock()
+	m.NextAttempt = q.now().Add(backoff)
+	q.mu.Unlock()
+	logger.WithError(err).WithField("retry_in", backoff).Warn("can't send mail, will retry")
+
+	if err := q.save(m); err != nil {
+		logger.WithError(err).Error("can't update spooled mail")
+	}
+}
+
+func (q *mailQueue) remove(m *queuedMail) {
+	q.mu.Lock()
+	q.pending = slices.DeleteFunc(q.pending, func(p *queuedMail) bool { return p == m })
+	q.mu.Unlock()
+
+	if q.dir != "" {
+		if err := os.Remove(q.path(m)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithField("mail_id", m.ID).WithError(err).Error("can't remove spooled mail")
+		}
+	}
+}
+
+// save writes the mail to the spool directory, if configured.
+func (q *mailQueue) save(m *queuedMail) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	b, err := json.Marshal(m)
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := q.path(m) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("can't spool mail: %w", err)
+	}
+	if err := os.Rename(tmp, q.path(m)); err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("can't spool mail: %w", err)
+	}
+
+	return nil
+}
+
+func (q *mailQueue) path(m *queuedMail) string {
+	return filepath.Join(q.dir, m.ID+spoolFileExt)
+}
+
+func (q *mailQueue) updateMetrics() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	mailQueueLength.Set(float64(len(q.pending)))
+
+	var oldest time.Duration
+	for _, m := range q.pending {
+		oldest = max(oldest, q.now().Sub(m.Created))
+	}
+	mailQueueOldestAge.Set(oldest.Seconds())
+}
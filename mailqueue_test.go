@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTransport struct {
+	mu       sync.Mutex
+	failures int
+	sent     []string
+	attempts int
+}
+
+func (f *fakeTransport) Send(_ context.Context, _ string, _ []string, msg io.WriterTo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.attempts++
+	if f.failures > 0 {
+		f.failures--
+
+		return errors.New("connection refused")
+	}
+
+	var b strings.Builder
+	_, _ = msg.WriteTo(&b)
+	f.sent = append(f.sent, b.String())
+
+	return nil
+}
+
+func (f *fakeTransport) sentMails() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.sent...)
+}
+
+// blockingTransport blocks deliveries until release is closed and records
+// the maximum number of concurrent deliveries.
+type blockingTransport struct {
+	release chan struct{}
+	active  atomic.Int32
+	peak    atomic.Int32
+}
+
+func (b *blockingTransport) Send(_ context.Context, _ string, _ []string, _ io.WriterTo) error {
+	n := b.active.Add(1)
+	defer b.active.Add(-1)
+	for {
+		peak := b.peak.Load()
+		if n <= peak || b.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	<-b.release
+
+	return nil
+}
+
+func testQueueConfig(dir string) *MailConfig {
+	return &MailConfig{
+		MailSpoolDir:     dir,
+		MailTimeout:      time.Second,
+		MailRetryInitial: time.Minute,
+		MailRetryMax:     time.Hour,
+		MailQueueMaxAge:  24 * time.Hour,
+	}
+}
+
+func TestMailQueue_DeliversAsynchronously(t *testing.T) {
+	transport := &fakeTransport{}
+	q, err := newMailQueue(transport, testQueueConfig(""))
+	require.NoError(t, err)
+	q.start()
+	defer q.Stop()
+
+	require.NoError(t, q.Send(context.Background(), "from@example.com", []string{"to@example.com"},
+		strings.NewReader("message")))
+
+	require.Eventually(t, func() bool { return len(transport.sentMails()) == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, "message", transport.sentMails()[0])
+	require.Eventually(t, func() bool { return testutil.ToFloat64(mailQueueLength) == 0 },
+		time.Second, 5*time.Millisecond)
+}
+
+func TestMailQueue_RetriesWithBackoff(t *testing.T) {
+	transport := &fakeTransport{failures: 2}
+	q, err := newMailQueue(transport, testQueueConfig(""))
+	require.NoError(t, err)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	failuresBefore := testutil.ToFloat64(mailDeliveryFailures)
+
+	require.NoError(t, q.Send(context.Background(), "f", []string{"t"}, strings.NewReader("message")))
+
+	q.deliverDue()
+	require.Len(t, q.pending, 1)
+	require.Equal(t, now.Add(time.Minute), q.pending[0].NextAttempt)
+	require.InDelta(t, 1, testutil.ToFloat64(mailQueueLength), 0)
+
+	q.deliverDue()
+	require.Equal(t, 1, transport.attempts, "not due yet")
+
+	now = now.Add(time.Minute)
+	q.deliverDue()
+	require.Equal(t, now.Add(2*time.Minute), q.pending[0].NextAttempt, "backoff doubles")
+	require.InDelta(t, 60, testutil.ToFloat64(mailQueueOldestAge), 0)
+
+	now = now.Add(2 * time.Minute)
+	q.deliverDue()
+	require.Empty(t, q.pending)
+	require.Len(t, transport.sentMails(), 1)
+	require.InDelta(t, failuresBefore+2, testutil.ToFloat64(mailDeliveryFailures), 0)
+}
+
+func TestMailQueue_GivesUpAfterMaxAge(t *testing.T) {
+	transport := &fakeTransport{failures: 100}
+	cfg := testQueueConfig(t.TempDir())
+	cfg.MailQueueMaxAge = time.Hour
+	q, err := newMailQueue(transport, cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	require.NoError(t, q.Send(context.Background(), "f", []string{"t"}, strings.NewReader("message")))
+	q.deliverDue()
+	require.Len(t, q.pending, 1)
+
+	now = now.Add(2 * time.Hour)
+	q.deliverDue()
+	require.Empty(t, q.pending)
+
+	entries, err := os.ReadDir(cfg.MailSpoolDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestMailQueue_NoMaxAgeRetriesForever(t *testing.T) {
+	transport := &fakeTransport{failures: 100}
+	cfg := testQueueConfig("")
+	cfg.MailQueueMaxAge = 0
+	q, err := newMailQueue(transport, cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	require.NoError(t, q.Send(context.Background(), "f", []string{"t"}, strings.NewReader("message")))
+	q.deliverDue()
+	now = now.Add(365 * 24 * time.Hour)
+	q.deliverDue()
+	require.Len(t, q.pending, 1)
+	require.Equal(t, 2, q.pending[0].Attempts)
+}
+
+func TestMailQueue_DeliversConcurrently(t *testing.T) {
+	transport := &blockingTransport{release: make(chan struct{})}
+	cfg := testQueueConfig("")
+	cfg.SmtpPoolSize = 3
+	q, err := newMailQueue(transport, cfg)
+	require.NoError(t, err)
+
+	for range 5 {
+		require.NoError(t, q.Send(context.Background(), "f", []string{"t"}, strings.NewReader("message")))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.deliverDue()
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return transport.active.Load() == 3 }, time.Second, 5*time.Millisecond)
+	close(transport.release)
+	<-done
+
+	require.Equal(t, int32(3), transport.peak.Load(), "limited by SMTP_POOL_SIZE")
+	require.Empty(t, q.pending)
+}
+
+func TestMailQueue_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newMailQueue(&fakeTransport{failures: 1}, testQueueConfig(dir))
+	require.NoError(t, err)
+
+	require.NoError(t, q.Send(context.Background(), "from@example.com", []string{"a@example.com", "b@example.com"},
+		strings.NewReader("message")))
+	q.deliverDue()
+
+	// a corrupt file doesn't prevent loading the others
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o600))
+
+	transport := &fakeTransport{}
+	restarted, err := newMailQueue(transport, testQueueConfig(dir))
+	require.NoError(t, err)
+	require.Len(t, restarted.pending, 1)
+	require.Equal(t, 1, restarted.pending[0].Attempts)
+	require.Equal(t, []string{"a@example.com", "b@example.com"}, restarted.pending[0].To)
+
+	restarted.pending[0].NextAttempt = time.Now()
+	restarted.deliverDue()
+	require.Equal(t, []string{"message"}, transport.sentMails())
+
+	_, err = os.Stat(filepath.Join(dir, "corrupt.json"))
+	require.NoError(t, err, "corrupt files are kept for inspection")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestMailQueue_StopDeliversPending(t *testing.T) {
+	transport := &fakeTransport{}
+	q, err := newMailQueue(transport, testQueueConfig(""))
+	require.NoError(t, err)
+	q.start()
+
+	require.NoError(t, q.Send(context.Background(), "f", []string{"t"}, strings.NewReader("message")))
+	q.Stop()
+
+	require.Len(t, transport.sentMails(), 1)
+}
+
+func TestMailNotifier_UsesQueue(t *testing.T) {
+	transport := &fakeTransport{}
+	q, err := newMailQueue(transport, testQueueConfig(""))
+	require.NoError(t, err)
+
+	n := &mailNotifier{
+		config:    &MailConfig{MailFrom: "crony@example.com", MailTo: "a@example.com"},
+		transport: q,
+	}
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+	require.Len(t, q.pending, 1)
+	require.Empty(t, transport.sentMails())
+
+	q.deliverDue()
+	require.Len(t, transport.sentMails(), 1)
+	require.Contains(t, transport.sentMails()[0], "backup")
+}
+
+func TestMailConfig_ValidateQueue(t *testing.T) {
+	require.Error(t, (&MailConfig{MailRetryInitial: -time.Second}).Validate())
+	require.Error(t, (&MailConfig{MailRetryMax: -time.Second}).Validate())
+	require.Error(t, (&MailConfig{MailQueueMaxAge: -time.Second}).Validate())
+}
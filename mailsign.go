@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/0xERR0R/crony/internal/dkim"
+)
+
+// signingTransport signs mails with DKIM before passing them on. The signer
+// is replaced when the key is reloaded.
+type signingTransport struct {
+	signer atomic.Pointer[dkim.Signer]
+	next   mailTransport
+}
+
+// newSigningTransport wraps next with DKIM signing, if a DKIM key is
+// configured.
+func newSigningTransport(config *MailConfig, next mailTransport) (mailTransport, error) {
+	if config.MailDkimPrivateKeyFile == "" {
+		return next, nil
+	}
+
+	signer, err := newDKIMSigner(config)
+	if err != nil {
+		return nil, err
+	}
+	t := &signingTransport{next: next}
+	t.setSigner(signer)
+
+	return t, nil
+}
+
+// newDKIMSigner reads the DKIM private key and creates the signer.
+func newDKIMSigner(config *MailConfig) (*dkim.Signer, error) {
+	keyPEM, err := os.ReadFile(config.MailDkimPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read DKIM private key: %w", err)
+	}
+
+	var headers []string
+	for h := range strings.SplitSeq(config.MailDkimHeaders, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+
+	return dkim.NewSigner(config.MailDkimDomain, config.MailDkimSelector, keyPEM, headers)
+}
+
+func (t *signingTransport) setSigner(signer *dkim.Signer) {
+	t.signer.Store(signer)
+}
+
+func (t *signingTransport) Send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return fmt.Errorf("can't compose mail: %w", err)
+	}
+
+	signed, err := t.signer.Load().Sign(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return t.next.Send(ctx, from, to, bytes.NewReader(signed))
+}
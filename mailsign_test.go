@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func dkimKeyFile(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "dkim.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	return file
+}
+
+func TestSigningTransport(t *testing.T) {
+	cfg := &MailConfig{
+		MailFrom:               "crony@example.com",
+		MailTo:                 "a@example.com",
+		MailDkimDomain:         "example.com",
+		MailDkimSelector:       "crony",
+		MailDkimPrivateKeyFile: dkimKeyFile(t),
+		MailDkimHeaders:        "From, Subject",
+	}
+	require.NoError(t, cfg.Validate())
+
+	next := &fakeTransport{}
+	transport, err := newSigningTransport(cfg, next)
+	require.NoError(t, err)
+
+	require.NoError(t, sendHTMLMail(context.Background(), transport, cfg, "subject", "<p>body</p>"))
+
+	mails := next.sentMails()
+	require.Len(t, mails, 1)
+	require.True(t, strings.HasPrefix(mails[0], "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n"+
+		" d=example.com; s=crony;"))
+	require.Contains(t, mails[0], " h=from:subject;\r\n")
+	require.Contains(t, mails[0], "Subject: subject")
+}
+
+func TestSigningTransport_NotConfigured(t *testing.T) {
+	next := &fakeTransport{}
+	transport, err := newSigningTransport(&MailConfig{}, next)
+	require.NoError(t, err)
+	require.Same(t, next, transport)
+}
+
+func TestSigningTransport_Errors(t *testing.T) {
+	_, err := newSigningTransport(&MailConfig{MailDkimPrivateKeyFile: "/does/not/exist"}, &fakeTransport{})
+	require.ErrorContains(t, err, "DKIM private key")
+
+	require.ErrorContains(t, (&MailConfig{MailDkimPrivateKeyFile: dkimKeyFile(t)}).Validate(), "MAIL_DKIM_DOMAIN")
+}
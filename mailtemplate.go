@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	texttemplate "text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mailTemplates are the user supplied templates for the subject and body of
+// mails. Missing templates are replaced by the built-in ones.
+type mailTemplates struct {
+	subject *texttemplate.Template
+	body    *template.Template
+}
+
+// loadMailTemplates parses and test-renders the template files. Empty file
+// names select the built-in templates.
+func loadMailTemplates(subjectFile, bodyFile string) (*mailTemplates, error) {
+	var t mailTemplates
+
+	if subjectFile != "" {
+		text, err := os.ReadFile(subjectFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read mail subject template: %w", err)
+		}
+		t.subject, err = texttemplate.New("mail-subject").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse mail subject template '%s': %w", subjectFile, err)
+		}
+		if err := t.subject.Execute(io.Discard, MailParams{}); err != nil {
+			return nil, fmt.Errorf("invalid mail subject template '%s': %w", subjectFile, err)
+		}
+	}
+
+	if bodyFile != "" {
+		text, err := os.ReadFile(bodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read mail body template: %w", err)
+		}
+		t.body, err = template.New("mail-body").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse mail body template '%s': %w", bodyFile, err)
+		}
+		if err := t.body.Execute(io.Discard, MailParams{}); err != nil {
+			return nil, fmt.Errorf("invalid mail body template '%s': %w", bodyFile, err)
+		}
+	}
+
+	return &t, nil
+}
+
+// subjectFor renders the subject, falling back to createTopic on errors.
+func (t *mailTemplates) subjectFor(params MailParams) string {
+	if t == nil || t.subject == nil {
+		return createTopic(params)
+	}
+
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, params); err != nil {
+		log.WithField("job", params.ContainerName).WithError(err).
+			Warn("can't render mail subject template, using built-in subject")
+
+		return createTopic(params)
+	}
+
+	// a subject must not span multiple lines
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// bodyFor renders the body, falling back to the built-in template on errors.
+func (t *mailTemplates) bodyFor(params MailParams) string {
+	var buf bytes.Buffer
+	if t != nil && t.body != nil {
+		err := t.body.Execute(&buf, params)
+		if err == nil {
+			return buf.String()
+		}
+		log.WithField("job", params.ContainerName).WithError(err).
+			Warn("can't render mail body template, using built-in template")
+		buf.Reset()
+	}
+
+	if err := newTemplate().Execute(&buf, params); err != nil {
+		log.WithField("job", params.ContainerName).WithError(err).Error("error during template processing")
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "template")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoadMailTemplates_BuiltIn(t *testing.T) {
+	templates, err := loadMailTemplates("", "")
+	require.NoError(t, err)
+
+	params := MailParams{ContainerName: "backup", ReturnCode: 1}
+	require.Equal(t, createTopic(params), templates.subjectFor(params))
+	require.Contains(t, templates.bodyFor(params), "<b>backup</b>")
+
+	var none *mailTemplates
+	require.Equal(t, createTopic(params), none.subjectFor(params))
+	require.Contains(t, none.bodyFor(params), "<b>backup</b>")
+}
+
+func TestLoadMailTemplates_Custom(t *testing.T) {
+	templates, err := loadMailTemplates(
+		writeTemplate(t, "{{if .Success}}OK{{else}}FAILED{{end}}: {{.ContainerName}}\n on {{.Hostname}}\n"),
+		writeTemplate(t, `<p>{{.ContainerName}} #{{.Attempt}} ({{.Schedule}}), next {{.NextRun.Format "15:04"}}, `+
+			`team {{index .Labels "team"}}: {{.StdOut}}</p>`),
+	)
+	require.NoError(t, err)
+
+	params := MailParams{
+		ContainerName: "backup",
+		ReturnCode:    2,
+		Hostname:      "node1",
+		Schedule:      "0 3 * * *",
+		Attempt:       4,
+		NextRun:       time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+		Labels:        map[string]string{"team": "ops"},
+		StdOut:        "<done>",
+	}
+	require.Equal(t, "FAILED: backup on node1", templates.subjectFor(params))
+	require.Equal(t, "<p>backup #4 (0 3 * * *), next 03:00, team ops: &lt;done&gt;</p>", templates.bodyFor(params))
+}
+
+func TestLoadMailTemplates_Invalid(t *testing.T) {
+	_, err := loadMailTemplates(filepath.Join(t.TempDir(), "missing"), "")
+	require.ErrorContains(t, err, "subject")
+
+	_, err = loadMailTemplates("", writeTemplate(t, "{{.Unknown}}"))
+	require.ErrorContains(t, err, "body")
+
+	_, err = loadMailTemplates(writeTemplate(t, "{{"), "")
+	require.ErrorContains(t, err, "parse")
+}
+
+func TestMailTemplates_FallbackOnRenderError(t *testing.T) {
+	// the template is valid for the empty test data, but fails for a real run
+	templates, err := loadMailTemplates(
+		writeTemplate(t, `{{if .Labels}}{{index .Labels.missing 0}}{{end}}`),
+		writeTemplate(t, `{{if .Labels}}{{index .Labels.missing 0}}{{end}}`),
+	)
+	require.NoError(t, err)
+
+	params := MailParams{ContainerName: "backup", Labels: map[string]string{"a": "b"}}
+	require.Equal(t, createTopic(params), templates.subjectFor(params))
+	require.Contains(t, templates.bodyFor(params), "<b>backup</b>")
+}
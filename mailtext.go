@@ -0,0 +1,50 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+//nolint:gochecknoglobals // compiled once, read-only
+var (
+	htmlPreRe        = regexp.MustCompile(`(?is)<pre[^>]*>(.*?)</pre>`)
+	htmlLineBreakRe  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6]|table)>`)
+	htmlCellBreakRe  = regexp.MustCompile(`(?i)</t[dh]>`)
+	htmlTagRe        = regexp.MustCompile(`<[^>]*>`)
+	multiBlankLineRe = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText converts a rendered mail body to plain text for the alternative
+// part of the mail. The content of <pre> blocks is kept verbatim, everything
+// else is stripped of tags and indentation.
+func htmlToText(body string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range htmlPreRe.FindAllStringSubmatchIndex(body, -1) {
+		b.WriteString(htmlFragmentToText(body[last:m[0]]))
+		b.WriteString("\n")
+		b.WriteString(html.UnescapeString(strings.Trim(htmlTagRe.ReplaceAllString(body[m[2]:m[3]], ""), "\n")))
+		b.WriteString("\n")
+		last = m[1]
+	}
+	b.WriteString(htmlFragmentToText(body[last:]))
+
+	text := strings.ReplaceAll(b.String(), "​", "")
+
+	return strings.TrimSpace(multiBlankLineRe.ReplaceAllString(text, "\n\n")) + "\n"
+}
+
+func htmlFragmentToText(fragment string) string {
+	fragment = strings.ReplaceAll(fragment, "\n", " ")
+	fragment = htmlLineBreakRe.ReplaceAllString(fragment, "\n")
+	fragment = htmlCellBreakRe.ReplaceAllString(fragment, " | ")
+	fragment = html.UnescapeString(htmlTagRe.ReplaceAllString(fragment, ""))
+
+	lines := strings.Split(fragment, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(strings.Join(strings.Fields(line), " "), " |")
+	}
+
+	return strings.Join(lines, "\n")
+}
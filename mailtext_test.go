@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	text := htmlToText(`
+		<p>
+			Container: <b>backup</b>,
+			return code <b>1</b>
+		</p>
+		<pre style="color: red">  indented &lt;tag&gt;
+second line</pre>
+		<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2 &amp; 3</td></tr></table>
+	`)
+
+	require.Equal(t, "Container: backup, return code 1\n\n"+
+		"  indented <tag>\nsecond line\n"+
+		"A | B\n1 | 2 & 3\n", text)
+}
+
+func TestHTMLToText_BuiltInTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newTemplate().Execute(&buf, MailParams{
+		ContainerName: "backup",
+		ReturnCode:    1,
+		StdOut:        "out <1>",
+		StdErr:        "err",
+	}))
+
+	text := htmlToText(buf.String())
+	require.Contains(t, text, "Container: backup,")
+	require.Contains(t, text, "\nout <1>\n")
+	require.NotContains(t, text, "<pre")
+	require.NotContains(t, text, "​")
+}
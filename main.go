@@ -6,11 +6,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/0xERR0R/crony/healthchecks"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/0xERR0R/crony/internal/dkim"
+	"github.com/0xERR0R/crony/internal/logarchive"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
@@ -19,6 +22,9 @@ import (
 const (
 	defaultPort     = 8080
 	shutdownTimeout = 30 * time.Second
+	// logArchivePruneInterval is the interval the log archive retention is
+	// enforced in.
+	logArchivePruneInterval = time.Hour
 )
 
 func main() {
@@ -26,42 +32,106 @@ func main() {
 
 	log.Info("starting crony...")
 
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifyCfg, err := loadNotifyConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archive := createLogArchive(cfg)
+
+	hcOptions, err := cfg.healthchecksOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	states, err := loadJobStates(cfg.StateFile)
+	if err != nil {
+		log.WithError(err).Error("can't load job states, starting without history")
+	}
+
+	mailCfg, err := loadMailConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailSender, queue, mailTransport := createMailDelivery(mailCfg)
+	mailThrottle, mailDigest := createMailBatching(mailCfg, mailTransport)
+
 	c := createAndStartCron()
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	dockerClient := NewDockerClient()
 	crony := Crony{
-		docker:             dockerClient,
-		cron:               c,
-		containerIdToJobId: make(map[string]cron.EntryID),
+		config:        cfg,
+		notifyConfig:  notifyCfg,
+		mailConfig:    mailCfg,
+		mailTransport: mailTransport,
+		mailSender:    mailSender,
+		docker:        dockerClient,
+		cron:          c,
+		archive:       archive,
+		hcOptions:     hcOptions,
+		states:        states,
+		mailThrottle:  mailThrottle,
+		mailDigest:    mailDigest,
+		jobs:          make(map[string]registeredJob),
+		ctx:           ctx,
 	}
 
-	crony.registerContainers()
+	if err := crony.registerContainers(); err != nil {
+		log.Fatal(err)
+	}
 
 	dockerClient.RegisterDockerEventListeners(crony.onContainerCreated, crony.onContainerDestroyed)
 
 	router := http.NewServeMux()
 	router.Handle("/metrics", promhttp.Handler())
+	if archive != nil && cfg.LogArchiveAPIToken != "" {
+		archive.RegisterHandlers(router, cfg.LogArchiveAPIToken)
+	}
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", defaultPort),
 		Handler: router,
 	}
 
-	signals := make(chan os.Signal, 1)
 	done := make(chan bool)
 
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		<-signals
+		for range reload {
+			if err := crony.reloadSecrets(); err != nil {
+				log.WithError(err).Error("can't reload secrets, keeping the previous ones")
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
 		log.Infof("Terminating...")
 
 		c.Stop()
+		if mailDigest != nil {
+			mailDigest.Stop()
+		}
+		if queue != nil {
+			queue.Stop()
+			mailSender.Close()
+		}
 		log.Info("Server is shutting down...")
 
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
+		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
 		}
 		close(done)
@@ -77,12 +147,36 @@ func main() {
 }
 
 type Crony struct {
-	docker             *DockerClient
-	cron               *cron.Cron
-	containerIdToJobId map[string]cron.EntryID
+	config        *Config
+	notifyConfig  *NotifyConfig
+	mailConfig    *MailConfig
+	docker        *DockerClient
+	cron          *cron.Cron
+	archive       *logarchive.Archive
+	hcOptions     []healthchecks.Option
+	states        *jobStates
+	mailTransport mailTransport
+	mailSender    *smtpSender
+	mailThrottle  *throttle
+	mailDigest    *digest
+	jobs          map[string]registeredJob
+	// ctx is the root context passed to the jobs, cancelled on shutdown
+	ctx context.Context //nolint:containedctx // the jobs are registered from Docker event callbacks
+	// mu serializes the registration of containers
+	mu sync.Mutex
+}
+
+// registeredJob is the cron job of a managed container.
+type registeredJob struct {
+	id        cron.EntryID
+	container CronyContainer
+	job       *ContainerJob
 }
 
 func (c *Crony) onContainerCreated(containerId string, _ string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	containers, err := c.docker.GetCronyContainers(containerId)
 	if err != nil {
 		log.Fatalf("can't list containers: %v", err)
@@ -94,82 +188,307 @@ func (c *Crony) onContainerCreated(containerId string, _ string) {
 }
 
 func (c *Crony) onContainerDestroyed(containerId string, containerName string) {
-	if jobId, ok := c.containerIdToJobId[containerId]; ok {
-		log.Infof("managed container '%s' was stopped, removing cron job", containerName)
-		c.cron.Remove(jobId)
-		delete(c.containerIdToJobId, containerId)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if registered, ok := c.jobs[containerId]; ok {
+		log.WithFields(log.Fields{
+			"job":          containerName,
+			"container_id": containerId,
+		}).Info("managed container was destroyed, removing cron job")
+		c.cron.Remove(registered.id)
+		delete(c.jobs, containerId)
 	}
 }
 
-func mailConfig(container CronyContainer) *MailConfig {
-	var mailCfg MailConfig
-	err := envconfig.Process("crony", &mailCfg)
-	if err != nil {
-		log.Error("can't parse mail config", err)
-
-		return nil
-	}
-
-	if err := mailCfg.Validate(); err != nil {
-		log.Error("mail config validation failed", err)
-
+// jobMailConfig applies the container's labels to the global mail config. It
+// returns nil if mails are not configured or the labels are invalid.
+func (c *Crony) jobMailConfig(container CronyContainer) *MailConfig {
+	if c.mailConfig == nil {
 		return nil
 	}
+	logger := log.WithField("job", container.Name)
+	mailCfg := *c.mailConfig
 
 	if container.MailPolicy != "" {
 		var jobMailPolicy MailPolicy
 		err := jobMailPolicy.Decode(container.MailPolicy)
 		if err != nil {
-			log.Error("can't parse job mail policy ", err)
+			logger.WithError(err).Error("can't parse job mail policy")
 		} else {
 			mailCfg.MailPolicy = jobMailPolicy
 		}
 	}
 
-	return &mailCfg
+	jobMailCfg, err := mailCfg.forContainer(container)
+	if err != nil {
+		logger.WithError(err).Error("invalid mail labels, mail notifications disabled")
+
+		return nil
+	}
+
+	return jobMailCfg
+}
+
+// notifications returns the notifiers configured for the container.
+func (c *Crony) notifications(container CronyContainer) []notification {
+	logger := log.WithField("job", container.Name)
+	var result []notification
+
+	if mailCfg := c.jobMailConfig(container); mailCfg != nil {
+		logger.Debug("using ", mailCfg)
+		templates, err := loadMailTemplates(mailCfg.MailSubjectTemplateFile, mailCfg.MailBodyTemplateFile)
+		if err != nil {
+			logger.WithError(err).Error("can't load mail templates, using built-in templates")
+		}
+		transport := c.mailTransport
+		if transport == nil {
+			transport, err = newSMTPSender(mailCfg)
+			if err != nil {
+				logger.WithError(err).Error("can't configure SMTP, mail notifications disabled")
+
+				return result
+			}
+		}
+		result = append(result, notification{
+			notifier: &mailNotifier{
+				config:    mailCfg,
+				transport: transport,
+				templates: templates,
+				throttle:  c.mailThrottle,
+				digest:    c.mailDigest,
+				archive:   c.archive,
+			},
+			policy:  mailCfg.MailPolicy,
+			timeout: mailCfg.MailTimeout,
+		})
+	}
+
+	for _, cfg := range c.notifyConfig.notifiers() {
+		n, err := cfg.notification(container)
+		if err != nil {
+			logger.WithError(err).Error("can't configure notifications")
+
+			continue
+		}
+		if n != nil {
+			logger.Debug("using ", cfg)
+			result = append(result, *n)
+		}
+	}
+
+	return result
 }
 
 func (c *Crony) registerContainer(container CronyContainer) {
-	log.Infof("... found managed container '%s'", container.Name)
+	logger := log.WithFields(log.Fields{
+		"job":          container.Name,
+		"container_id": container.ID,
+	})
+	logger.WithField("schedule", container.CronString).Info("registering managed container")
 
-	log.Infof("... registering container with '%s'", container.CronString)
+	outputMatcher, err := NewOutputMatcher(container.FailIfOutputMatches, container.SucceedOnlyIfOutputMatches)
+	if err != nil {
+		logger.WithError(err).Error("can't register container")
+
+		return
+	}
 
 	var hcCheck *healthchecks.Check
 	if container.HcUuid != "" {
-		hcCheck = healthchecks.NewCheck(container.HcUuid, os.Getenv("HC_BASE_URL"))
+		hcCheck = healthchecks.NewCheck(container.HcUuid, c.config.HcBaseURL, c.hcOptions...)
 	}
 
-	job := cron.NewChain(cron.SkipIfStillRunning(&SkipLogger{containerName: container.Name})).Then(&ContainerJob{
+	containerJob := &ContainerJob{
 		docker:        c.docker,
+		containerID:   container.ID,
 		containerName: container.Name,
-		mailConfig:    mailConfig(container),
+		schedule:      container.CronString,
+		labels:        container.Labels,
+		config:        c.config.forContainer(container),
 		hc:            hcCheck,
-	})
+		outputMatcher: outputMatcher,
+		archive:       c.archive,
+		states:        c.states,
+		ctx:           c.ctx,
+	}
+	containerJob.setNotifications(c.notifications(container))
+
+	job := cron.NewChain(cron.SkipIfStillRunning(&SkipLogger{containerName: container.Name})).Then(containerJob)
 	id, err := c.cron.AddJob(container.CronString, job)
 	if err != nil {
-		log.Fatal("can't register job ", err)
+		logger.WithError(err).Fatal("can't register job")
+	}
+
+	c.jobs[container.ID] = registeredJob{id: id, container: container, job: containerJob}
+}
+
+// reloadSecrets reads the secret files and the DKIM key again and replaces the
+// notifiers of the registered jobs, so they use the new secrets. Nothing is
+// applied if a secret can't be read.
+func (c *Crony) reloadSecrets() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	notifyCfg := *c.notifyConfig
+	if err := notifyCfg.readSecretFiles(); err != nil {
+		return err
+	}
+
+	var mailCfg *MailConfig
+	if c.mailConfig != nil {
+		cfg := *c.mailConfig
+		if err := readSecretFiles(&cfg); err != nil {
+			return err
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid mail config: %w", err)
+		}
+		mailCfg = &cfg
+	}
+
+	signing, _ := c.mailTransport.(*signingTransport)
+	var signer *dkim.Signer
+	if signing != nil {
+		var err error
+		if signer, err = newDKIMSigner(mailCfg); err != nil {
+			return err
+		}
+	}
+
+	if c.mailSender != nil {
+		c.mailSender.setCredentials(mailCfg)
+	}
+	if signing != nil {
+		signing.setSigner(signer)
 	}
+	c.notifyConfig, c.mailConfig = &notifyCfg, mailCfg
+	for _, registered := range c.jobs {
+		registered.job.setNotifications(c.notifications(registered.container))
+	}
+	log.WithField("jobs", len(c.jobs)).Info("secrets reloaded")
 
-	c.containerIdToJobId[container.ID] = id
+	return nil
 }
 
-func (c *Crony) registerContainers() {
+func (c *Crony) registerContainers() error {
 	log.Info("starting container registration")
 	containers, err := c.docker.GetCronyContainers("")
 	if err != nil {
-		log.Fatalf("can't list containers: %v", err)
+		return fmt.Errorf("can't list containers: %w", err)
 	}
 	for _, container := range containers {
 		c.registerContainer(container)
 	}
 	log.Info("container registration finished")
+
+	return nil
+}
+
+// createMailDelivery creates the SMTP sender and starts the mail queue, if
+// mails are configured. Mails are sent via the returned transport, which
+// signs them before they are queued.
+func createMailDelivery(mailCfg *MailConfig) (*smtpSender, *mailQueue, mailTransport) {
+	if mailCfg == nil {
+		log.Info("mail notifications are disabled, set SMTP_HOST, SMTP_PORT, MAIL_TO and MAIL_FROM to enable them")
+
+		return nil, nil, nil
+	}
+	log.Info("using ", mailCfg)
+
+	sender, err := newSMTPSender(mailCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if mailCfg.SmtpSelfTest {
+		ctx, cancel := context.WithTimeout(context.Background(), mailCfg.SmtpDialTimeout)
+		err := sender.Check(ctx)
+		cancel()
+		if err != nil {
+			log.WithError(err).Fatal("SMTP self-test failed")
+		}
+		log.WithField("host", mailCfg.SmtpHost).Info("SMTP self-test succeeded")
+	}
+
+	queue, err := newMailQueue(sender, mailCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err := newSigningTransport(mailCfg, queue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mailCfg.MailDkimPrivateKeyFile != "" {
+		log.WithFields(log.Fields{"domain": mailCfg.MailDkimDomain, "selector": mailCfg.MailDkimSelector}).
+			Info("signing mails with DKIM")
+	}
+	queue.start()
+
+	return sender, queue, transport
+}
+
+// createMailBatching returns the rate limit and the digest shared by the
+// mail notifiers of all jobs, if configured.
+func createMailBatching(mailCfg *MailConfig, transport mailTransport) (*throttle, *digest) {
+	if mailCfg == nil {
+		return nil, nil
+	}
+
+	var mailDigest *digest
+	if mailCfg.MailDigestInterval > 0 {
+		mailDigest = newDigest(mailCfg.MailDigestInterval, func(config *MailConfig, results []RunResult) error {
+			ctx, cancel := withTimeout(context.Background(), config.MailTimeout)
+			defer cancel()
+
+			return SendDigestMail(ctx, transport, config, results)
+		})
+		mailDigest.start()
+		log.WithField("interval", mailCfg.MailDigestInterval).Info("sending mails as digest")
+	}
+
+	return newThrottle(mailCfg.MailThrottleJobInterval, mailCfg.MailThrottleGlobalLimit,
+		mailCfg.MailThrottleGlobalWindow), mailDigest
+}
+
+func createLogArchive(cfg *Config) *logarchive.Archive {
+	if cfg.LogArchiveDir == "" {
+		return nil
+	}
+
+	archive, err := logarchive.New(cfg.LogArchiveDir, cfg.LogArchiveMaxAge, cfg.LogArchiveMaxSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go pruneLogArchive(archive)
+	log.WithField("dir", cfg.LogArchiveDir).Info("archiving job output")
+
+	return archive
+}
+
+// pruneLogArchive enforces the retention of the log archive on startup and
+// then periodically.
+func pruneLogArchive(archive *logarchive.Archive) {
+	for {
+		if err := archive.Prune(); err != nil {
+			log.WithError(err).Error("can't prune log archive")
+		}
+		time.Sleep(logArchivePruneInterval)
+	}
 }
 
 func configureLogging() {
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	default:
+		log.Warnf("unknown LOG_FORMAT '%s', please use one of 'text, json'", format)
+	}
 	level, err := log.ParseLevel(os.Getenv("LOG_LEVEL"))
 	if err == nil {
 		log.SetLevel(level)
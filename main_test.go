@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,11 +18,28 @@ func setBaseSMTPEnv(t *testing.T) {
 	t.Setenv("MAIL_FROM", "from@example.com")
 }
 
-func TestMailConfig_HappyPath(t *testing.T) {
+func clearSMTPEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range requiredMailEnv {
+		t.Setenv(name, "")
+	}
+}
+
+func mailCrony(t *testing.T) *Crony {
+	t.Helper()
+	cfg, err := loadMailConfig()
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+
+	return &Crony{notifyConfig: &NotifyConfig{}, mailConfig: cfg}
+}
+
+func TestLoadMailConfig_HappyPath(t *testing.T) {
 	setBaseSMTPEnv(t)
 	t.Setenv("MAIL_POLICY", "always")
 
-	cfg := mailConfig(CronyContainer{})
+	cfg, err := loadMailConfig()
+	require.NoError(t, err)
 	require.NotNil(t, cfg)
 	require.Equal(t, "smtp.example.com", cfg.SmtpHost)
 	require.Equal(t, 587, cfg.SmtpPort)
@@ -27,29 +48,116 @@ func TestMailConfig_HappyPath(t *testing.T) {
 	require.Equal(t, Always, cfg.MailPolicy)
 }
 
-func TestMailConfig_MissingRequired_ReturnsNil(t *testing.T) {
-	t.Setenv("SMTP_HOST", "")
-	t.Setenv("SMTP_PORT", "")
-	t.Setenv("MAIL_TO", "")
-	t.Setenv("MAIL_FROM", "")
-	cfg := mailConfig(CronyContainer{})
+func TestLoadMailConfig_NotConfigured(t *testing.T) {
+	clearSMTPEnv(t)
+
+	cfg, err := loadMailConfig()
+	require.NoError(t, err)
 	require.Nil(t, cfg)
 }
 
-func TestMailConfig_ContainerLabelOverridesGlobal(t *testing.T) {
+func TestLoadMailConfig_Incomplete(t *testing.T) {
+	clearSMTPEnv(t)
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_TO", "to@example.com")
+
+	_, err := loadMailConfig()
+	require.ErrorContains(t, err, "SMTP_PORT, MAIL_FROM must be set")
+}
+
+func TestLoadMailConfig_Invalid(t *testing.T) {
+	setBaseSMTPEnv(t)
+
+	t.Setenv("SMTP_PORT", "smtp")
+	_, err := loadMailConfig()
+	require.ErrorContains(t, err, "can't parse mail config")
+
+	t.Setenv("SMTP_PORT", "587")
+	t.Setenv("SMTP_USER", "user")
+	_, err = loadMailConfig()
+	require.ErrorContains(t, err, "SMTP_PASSWORD")
+
+	t.Setenv("SMTP_USER", "")
+	t.Setenv("MAIL_BODY_TEMPLATE_FILE", "/does/not/exist")
+	_, err = loadMailConfig()
+	require.Error(t, err)
+}
+
+func TestJobMailConfig_NotConfigured(t *testing.T) {
+	require.Nil(t, (&Crony{}).jobMailConfig(CronyContainer{MailPolicy: "always"}))
+}
+
+func TestJobMailConfig_ContainerLabelOverridesGlobal(t *testing.T) {
 	setBaseSMTPEnv(t)
 	t.Setenv("MAIL_POLICY", "never")
 
-	cfg := mailConfig(CronyContainer{MailPolicy: "always"})
+	cfg := mailCrony(t).jobMailConfig(CronyContainer{MailPolicy: "always"})
 	require.NotNil(t, cfg)
 	require.Equal(t, Always, cfg.MailPolicy)
 }
 
-func TestMailConfig_InvalidContainerLabel_FallsBackToGlobal(t *testing.T) {
+func TestJobMailConfig_InvalidContainerLabel_FallsBackToGlobal(t *testing.T) {
 	setBaseSMTPEnv(t)
 	t.Setenv("MAIL_POLICY", "onerror")
 
-	cfg := mailConfig(CronyContainer{MailPolicy: "garbage"})
+	cfg := mailCrony(t).jobMailConfig(CronyContainer{MailPolicy: "garbage"})
 	require.NotNil(t, cfg)
 	require.Equal(t, OnError, cfg.MailPolicy)
 }
+
+func TestJobMailConfig_RecipientLabels(t *testing.T) {
+	setBaseSMTPEnv(t)
+	c := mailCrony(t)
+
+	cfg := c.jobMailConfig(CronyContainer{Labels: map[string]string{mailToLabel: "team@example.com"}})
+	require.NotNil(t, cfg)
+	require.Equal(t, "team@example.com", cfg.MailTo)
+	require.Equal(t, "to@example.com", c.mailConfig.MailTo, "the global config is not modified")
+
+	cfg = c.jobMailConfig(CronyContainer{Labels: map[string]string{mailToLabel: "invalid"}})
+	require.Nil(t, cfg, "mails are disabled for containers with invalid addresses")
+}
+
+func TestConfigureLogging_Format(t *testing.T) {
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+
+	t.Setenv("LOG_FORMAT", "json")
+	configureLogging()
+	require.IsType(t, &logrus.JSONFormatter{}, logrus.StandardLogger().Formatter)
+
+	t.Setenv("LOG_FORMAT", "")
+	configureLogging()
+	require.IsType(t, &logrus.TextFormatter{}, logrus.StandardLogger().Formatter)
+}
+
+func TestNotifications_MailConfigured(t *testing.T) {
+	setBaseSMTPEnv(t)
+	t.Setenv("MAIL_POLICY", "onerror")
+
+	n := mailCrony(t).notifications(CronyContainer{})
+	require.Len(t, n, 1)
+	require.Equal(t, "mail", n[0].notifier.Name())
+	require.Equal(t, OnError, n[0].policy)
+	require.Equal(t, time.Minute, n[0].timeout)
+}
+
+func TestNotifications_MailNotConfigured(t *testing.T) {
+	require.Empty(t, (&Crony{notifyConfig: &NotifyConfig{}}).notifications(CronyContainer{}))
+}
+
+func TestRegisterContainer_PassesRootContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Crony{
+		config:       &Config{CaptureTailSize: 1024},
+		notifyConfig: &NotifyConfig{},
+		cron:         cron.New(),
+		jobs:         map[string]registeredJob{},
+		ctx:          ctx,
+	}
+	c.registerContainer(CronyContainer{ID: "abc", Name: "backup", CronString: "@daily"})
+
+	job := c.jobs["abc"].job
+	require.NoError(t, job.ctx.Err())
+	cancel()
+	require.ErrorIs(t, job.ctx.Err(), context.Canceled, "the pings of running jobs are cancelled on shutdown")
+}
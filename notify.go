@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+//nolint:gochecknoglobals // prometheus metrics are conventionally package-level
+var notificationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crony_notification_count",
+	Help: "Number of sent notifications",
+}, []string{"container_name", "notifier", "success"})
+
+//nolint:gochecknoglobals // prometheus metrics are conventionally package-level
+var throttledCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crony_notification_throttled_count",
+	Help: "Number of notifications dropped by rate limiting",
+}, []string{"container_name", "notifier"})
+
+// NotifyConfig holds the global configuration of the notifiers. Mail is
+// configured separately by MailConfig.
+type NotifyConfig struct {
+	Webhook      WebhookConfig
+	Chat         ChatConfig
+	Ntfy         NtfyConfig
+	Gotify       GotifyConfig
+	Telegram     TelegramConfig
+	Alertmanager AlertmanagerConfig
+}
+
+// notifierConfig is the global configuration of a notifier.
+type notifierConfig interface {
+	fmt.Stringer
+	// notification returns the notification configured for the container
+	// or nil, if the notifier is not enabled for it.
+	notification(container CronyContainer) (*notification, error)
+}
+
+func (c *NotifyConfig) notifiers() []notifierConfig {
+	return []notifierConfig{c.Webhook, c.Chat, c.Ntfy, c.Gotify, c.Telegram, c.Alertmanager}
+}
+
+// readSecretFiles reads the secrets of all notifiers from files.
+func (c *NotifyConfig) readSecretFiles() error {
+	for _, spec := range []any{&c.Webhook, &c.Chat, &c.Ntfy, &c.Gotify, &c.Telegram, &c.Alertmanager} {
+		if err := readSecretFiles(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadNotifyConfig() (*NotifyConfig, error) {
+	var cfg NotifyConfig
+	if err := processConfig(&cfg.Webhook); err != nil {
+		return nil, fmt.Errorf("can't parse webhook config: %w", err)
+	}
+	if cfg.Webhook.URL != "" {
+		if _, err := newWebhookNotifier(cfg.Webhook); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := processConfig(&cfg.Chat); err != nil {
+		return nil, fmt.Errorf("can't parse chat config: %w", err)
+	}
+	if err := processConfig(&cfg.Ntfy); err != nil {
+		return nil, fmt.Errorf("can't parse ntfy config: %w", err)
+	}
+	if cfg.Ntfy.URL != "" && cfg.Ntfy.Topic != "" {
+		if _, err := newNtfyNotifier(cfg.Ntfy); err != nil {
+			return nil, err
+		}
+	}
+	if err := processConfig(&cfg.Gotify); err != nil {
+		return nil, fmt.Errorf("can't parse gotify config: %w", err)
+	}
+	if cfg.Gotify.URL != "" && cfg.Gotify.Token != "" {
+		if _, err := newGotifyNotifier(cfg.Gotify); err != nil {
+			return nil, err
+		}
+	}
+	if err := processConfig(&cfg.Telegram); err != nil {
+		return nil, fmt.Errorf("can't parse telegram config: %w", err)
+	}
+	if err := processConfig(&cfg.Alertmanager); err != nil {
+		return nil, fmt.Errorf("can't parse alertmanager config: %w", err)
+	}
+	cfg.Alertmanager.firing = newFiringAlerts()
+	if cfg.Alertmanager.URL != "" {
+		if _, err := newAlertmanagerNotifier(cfg.Alertmanager); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validateTimeouts(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// validateTimeouts rejects negative notifier timeouts, 0 disables the limit.
+func (c *NotifyConfig) validateTimeouts() error {
+	for _, t := range []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"WEBHOOK_TIMEOUT", c.Webhook.Timeout},
+		{"CHAT_TIMEOUT", c.Chat.Timeout},
+		{"NTFY_TIMEOUT", c.Ntfy.Timeout},
+		{"GOTIFY_TIMEOUT", c.Gotify.Timeout},
+		{"TELEGRAM_TIMEOUT", c.Telegram.Timeout},
+		{"ALERTMANAGER_TIMEOUT", c.Alertmanager.Timeout},
+	} {
+		if t.timeout < 0 {
+			return fmt.Errorf("%s must not be negative", t.name)
+		}
+	}
+
+	return nil
+}
+
+// RunResult describes a finished job run.
+type RunResult struct {
+	ContainerName string
+	ContainerID   string
+	RunID         string
+	Hostname      string
+	Schedule      string
+	ReturnCode    int64
+	StartTime     time.Time
+	EndTime       time.Time
+	Duration      time.Duration
+	StdOut        string
+	StdErr        string
+	// Output is the chronologically interleaved stdout and stderr, each line
+	// prefixed with its time and stream.
+	Output string
+	// OutputFailure is set if the output matchers turned the run into a failure.
+	OutputFailure string
+	// OutputSuccess is set if the output matchers turned a non-zero exit
+	// into a success.
+	OutputSuccess string
+	// StateChanged is set if the run failed after a success or vice versa.
+	StateChanged bool
+	// FailedRuns is the number of consecutive failed runs before this one.
+	FailedRuns int
+	// previous is the state of the job before this run.
+	previous jobState
+	// Attempt is the number of this run since the job was registered.
+	Attempt int
+	// NextRun is the next scheduled run of the job.
+	NextRun time.Time
+	// Labels holds all labels of the container.
+	Labels map[string]string
+}
+
+// Success reports whether the job exited with 0 and its output was accepted.
+func (r RunResult) Success() bool {
+	return (r.ReturnCode == 0 || r.OutputSuccess != "") && r.OutputFailure == ""
+}
+
+// Recovered reports whether the job succeeded after failed runs.
+func (r RunResult) Recovered() bool {
+	return r.Success() && r.FailedRuns > 0
+}
+
+// Outcome returns "success" or "failure", e.g. for structured log fields.
+func (r RunResult) Outcome() string {
+	if r.Success() {
+		return "success"
+	}
+
+	return "failure"
+}
+
+func (r RunResult) ShortDuration() string {
+	return durafmt.Parse(r.Duration.Truncate(time.Second)).String()
+}
+
+// excerpt returns the tail of the job output for notifiers with limited
+// message size, see tailLines.
+func (r RunResult) excerpt(lines, maxLength int) string {
+	output := r.Output
+	if output == "" {
+		output = r.StdOut + r.StdErr
+	}
+
+	return tailLines(output, lines, maxLength)
+}
+
+// tailLines returns at most the last n lines of s, cut to maxLength bytes at
+// a line boundary if possible.
+func tailLines(s string, n, maxLength int) string {
+	s = strings.TrimRight(s, "\r\n")
+	if s == "" || n <= 0 {
+		return ""
+	}
+
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	tail := strings.Join(lines, "\n")
+
+	if len(tail) > maxLength {
+		tail = tail[len(tail)-maxLength:]
+		if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+			tail = tail[i+1:]
+		}
+		tail = strings.ToValidUTF8(tail, "")
+	}
+
+	return tail
+}
+
+// Notifier sends the result of a job run to a notification channel.
+type Notifier interface {
+	// Name identifies the notifier in logs and metrics.
+	Name() string
+	// Notify sends the result. It should return once ctx is done.
+	Notify(ctx context.Context, result RunResult) error
+}
+
+// notification is a notifier configured for a job, together with the policy
+// deciding which results it receives and the time it may take.
+type notification struct {
+	notifier Notifier
+	policy   MailPolicy
+	timeout  time.Duration
+}
+
+func (n notification) applies(result RunResult) bool {
+	switch n.policy {
+	case Always:
+		return true
+	case OnError:
+		return !result.Success()
+	case OnChange:
+		return result.StateChanged
+	default:
+		return false
+	}
+}
+
+// withTimeout returns a copy of ctx limited to timeout, 0 disables the limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// notifyAll concurrently sends the result to all applicable notifiers and
+// waits until they are finished or timed out.
+func notifyAll(logger *log.Entry, notifications []notification, result RunResult) {
+	var wg sync.WaitGroup
+	for _, n := range notifications {
+		if !n.applies(result) {
+			continue
+		}
+
+		wg.Go(func() {
+			ctx, cancel := withTimeout(context.Background(), n.timeout)
+			defer cancel()
+
+			logger := logger.WithField("notifier", n.notifier.Name())
+			err := n.notifier.Notify(ctx, result)
+			if errors.Is(err, errThrottled) {
+				throttledCount.With(prometheus.Labels{
+					"container_name": result.ContainerName,
+					"notifier":       n.notifier.Name(),
+				}).Inc()
+				logger.Info("notification throttled")
+
+				return
+			}
+			notificationCount.With(prometheus.Labels{
+				"container_name": result.ContainerName,
+				"notifier":       n.notifier.Name(),
+				"success":        strconv.FormatBool(err == nil),
+			}).Inc()
+			if err != nil {
+				logger.WithError(err).Error("can't send notification")
+
+				return
+			}
+			logger.Debug("notification sent")
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNotifier struct {
+	name  string
+	err   error
+	delay time.Duration
+
+	mu      sync.Mutex
+	results []RunResult
+}
+
+func (f *fakeNotifier) Name() string {
+	return f.name
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, result RunResult) error {
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, result)
+
+	return f.err
+}
+
+func (f *fakeNotifier) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.results)
+}
+
+func TestNotification_Applies(t *testing.T) {
+	success := RunResult{}
+	failure := RunResult{ReturnCode: 1}
+
+	require.False(t, notification{policy: Never}.applies(success))
+	require.False(t, notification{policy: Never}.applies(failure))
+	require.True(t, notification{policy: Always}.applies(success))
+	require.True(t, notification{policy: Always}.applies(failure))
+	require.False(t, notification{policy: OnError}.applies(success))
+	require.True(t, notification{policy: OnError}.applies(failure))
+
+	require.False(t, notification{policy: OnChange}.applies(success))
+	require.False(t, notification{policy: OnChange}.applies(failure))
+	require.True(t, notification{policy: OnChange}.applies(RunResult{StateChanged: true}))
+	require.True(t, notification{policy: OnChange}.applies(RunResult{ReturnCode: 1, StateChanged: true}))
+}
+
+func TestNotifyAll_RespectsPolicies(t *testing.T) {
+	always := &fakeNotifier{name: "always"}
+	onError := &fakeNotifier{name: "onerror"}
+	never := &fakeNotifier{name: "never"}
+
+	notifyAll(logrus.NewEntry(logrus.StandardLogger()), []notification{
+		{notifier: always, policy: Always, timeout: time.Second},
+		{notifier: onError, policy: OnError, timeout: time.Second},
+		{notifier: never, policy: Never, timeout: time.Second},
+	}, RunResult{ContainerName: "policies"})
+
+	require.Equal(t, 1, always.count())
+	require.Equal(t, 0, onError.count())
+	require.Equal(t, 0, never.count())
+}
+
+func TestNotifyAll_RunsConcurrently(t *testing.T) {
+	first := &fakeNotifier{name: "first", delay: 200 * time.Millisecond}
+	second := &fakeNotifier{name: "second", delay: 200 * time.Millisecond}
+
+	start := time.Now()
+	notifyAll(logrus.NewEntry(logrus.StandardLogger()), []notification{
+		{notifier: first, policy: Always, timeout: time.Second},
+		{notifier: second, policy: Always, timeout: time.Second},
+	}, RunResult{ContainerName: "concurrent"})
+
+	require.Less(t, time.Since(start), 390*time.Millisecond)
+	require.Equal(t, 1, first.count())
+	require.Equal(t, 1, second.count())
+}
+
+func TestNotifyAll_TimeoutAndErrors(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	slow := &fakeNotifier{name: "slow", delay: time.Second}
+	failing := &fakeNotifier{name: "failing", err: errors.New("boom")}
+
+	notifyAll(logrus.NewEntry(logrus.StandardLogger()), []notification{
+		{notifier: slow, policy: Always, timeout: 10 * time.Millisecond},
+		{notifier: failing, policy: Always, timeout: time.Second},
+	}, RunResult{ContainerName: "errors"})
+
+	require.Equal(t, 0, slow.count())
+
+	errorsLogged := map[any]bool{}
+	for _, e := range hook.AllEntries() {
+		if e.Level == logrus.ErrorLevel {
+			errorsLogged[e.Data["notifier"]] = true
+		}
+	}
+	require.True(t, errorsLogged["slow"])
+	require.True(t, errorsLogged["failing"])
+
+	require.InDelta(t, 1, testutil.ToFloat64(notificationCount.With(prometheus.Labels{
+		"container_name": "errors", "notifier": "failing", "success": "false",
+	})), 0)
+	require.InDelta(t, 1, testutil.ToFloat64(notificationCount.With(prometheus.Labels{
+		"container_name": "errors", "notifier": "slow", "success": "false",
+	})), 0)
+}
+
+func TestNotifyAll_ZeroTimeoutDisablesLimit(t *testing.T) {
+	n := &fakeNotifier{name: "unlimited", delay: 10 * time.Millisecond}
+
+	notifyAll(logrus.NewEntry(logrus.StandardLogger()), []notification{
+		{notifier: n, policy: Always},
+	}, RunResult{ContainerName: "unlimited"})
+
+	require.Equal(t, 1, n.count())
+}
+
+func TestLoadNotifyConfig_NegativeTimeout(t *testing.T) {
+	t.Setenv("CHAT_TIMEOUT", "-1s")
+
+	_, err := loadNotifyConfig()
+	require.ErrorContains(t, err, "CHAT_TIMEOUT")
+}
+
+func TestNotifyAll_Throttled(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	throttled := &fakeNotifier{name: "throttled", err: errThrottled}
+
+	notifyAll(logrus.NewEntry(logrus.StandardLogger()), []notification{
+		{notifier: throttled, policy: Always, timeout: time.Second},
+	}, RunResult{ContainerName: "throttle"})
+
+	for _, e := range hook.AllEntries() {
+		require.NotEqual(t, logrus.ErrorLevel, e.Level)
+	}
+	require.InDelta(t, 1, testutil.ToFloat64(throttledCount.With(prometheus.Labels{
+		"container_name": "throttle", "notifier": "throttled",
+	})), 0)
+	require.InDelta(t, 0, testutil.ToFloat64(notificationCount.With(prometheus.Labels{
+		"container_name": "throttle", "notifier": "throttled", "success": "false",
+	})), 0)
+}
+
+func TestTailLines(t *testing.T) {
+	require.Empty(t, tailLines("", 5, 100))
+	require.Empty(t, tailLines("a\nb", 0, 100))
+	require.Equal(t, "a\nb", tailLines("a\nb\n", 5, 100))
+	require.Equal(t, "c\nd", tailLines("a\nb\nc\nd", 2, 100))
+
+	long := strings.Repeat("x", 50) + "\n" + strings.Repeat("y", 10)
+	require.Equal(t, strings.Repeat("y", 10), tailLines(long, 5, 20))
+	require.Equal(t, strings.Repeat("y", 10), tailLines(strings.Repeat("y", 30), 5, 10))
+}
+
+func TestRunResult_Excerpt(t *testing.T) {
+	require.Equal(t, "b\nc", RunResult{Output: "a\nb\nc\n", StdOut: "ignored"}.excerpt(2, 100))
+	require.Equal(t, "out\nerr", RunResult{StdOut: "out\n", StdErr: "err\n"}.excerpt(2, 100))
+}
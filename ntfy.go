@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// ntfy rejects messages above 4096 bytes, leave room for the subject
+	maxPushExcerptLength = 3000
+	maxPushExcerptLines  = 20
+)
+
+// NtfyConfig configures push notifications via https://ntfy.sh or a self
+// hosted ntfy server.
+type NtfyConfig struct {
+	URL             string        `envconfig:"ntfy_url"`
+	Topic           string        `envconfig:"ntfy_topic"`
+	Token           string        `envconfig:"ntfy_token"    secret:"true"`
+	User            string        `envconfig:"ntfy_user"`
+	Password        string        `envconfig:"ntfy_password" secret:"true"`
+	PrioritySuccess int           `default:"3"               envconfig:"ntfy_priority_success"`
+	PriorityFailure int           `default:"4"               envconfig:"ntfy_priority_failure"`
+	Retries         int           `default:"3"               envconfig:"ntfy_retries"`
+	Policy          MailPolicy    `default:"never"           envconfig:"ntfy_policy"`
+	Timeout         time.Duration `default:"30s"             envconfig:"ntfy_timeout"`
+}
+
+func (c NtfyConfig) String() string {
+	return fmt.Sprintf("ntfy config [url=%s, topic=%s, user=%s, policy=%s]", redactURL(c.URL), c.Topic, c.User, c.Policy)
+}
+
+// forContainer applies the container's label overrides.
+func (c NtfyConfig) forContainer(container CronyContainer) (NtfyConfig, error) {
+	if v, ok := container.Labels[ntfyURLLabel]; ok {
+		c.URL = v
+	}
+	if v, ok := container.Labels[ntfyTopicLabel]; ok {
+		c.Topic = v
+	}
+	if v, ok := container.Labels[ntfyPolicyLabel]; ok {
+		if err := c.Policy.Decode(v); err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", ntfyPolicyLabel, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c NtfyConfig) notification(container CronyContainer) (*notification, error) {
+	cfg, err := c.forContainer(container)
+	if err != nil || cfg.URL == "" || cfg.Topic == "" {
+		return nil, err
+	}
+
+	notifier, err := newNtfyNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification{notifier: notifier, policy: cfg.Policy, timeout: cfg.Timeout}, nil
+}
+
+type ntfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags"`
+}
+
+// ntfyNotifier publishes the result of a job run to a ntfy topic.
+type ntfyNotifier struct {
+	config NtfyConfig
+	client *http.Client
+}
+
+func newNtfyNotifier(config NtfyConfig) (*ntfyNotifier, error) {
+	if config.URL == "" || config.Topic == "" {
+		return nil, errors.New("ntfy URL and topic must be provided")
+	}
+	if config.Token != "" && config.User != "" {
+		return nil, errors.New("NTFY_TOKEN and NTFY_USER can't be used together")
+	}
+	for _, p := range []int{config.PrioritySuccess, config.PriorityFailure} {
+		if p < 1 || p > 5 {
+			return nil, fmt.Errorf("invalid ntfy priority %d, must be between 1 and 5", p)
+		}
+	}
+
+	return &ntfyNotifier{config: config, client: &http.Client{}}, nil
+}
+
+func (n *ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, result RunResult) error {
+	msg := ntfyMessage{
+		Topic:    n.config.Topic,
+		Title:    createTopic(result),
+		Message:  pushMessage(result),
+		Priority: n.config.PrioritySuccess,
+		Tags:     []string{"crony", result.Outcome()},
+	}
+	if !result.Success() {
+		msg.Priority = n.config.PriorityFailure
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// publishing as JSON to the root URL avoids encoding the title as header
+	url := strings.TrimSuffix(n.config.URL, "/") + "/"
+
+	err = postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		switch {
+		case n.config.Token != "":
+			req.Header.Set("Authorization", "Bearer "+n.config.Token)
+		case n.config.User != "":
+			req.SetBasicAuth(n.config.User, n.config.Password)
+		}
+
+		return req, nil
+	})
+
+	return redactError(err, urlSecrets(url)...)
+}
+
+// pushMessage returns the body of push notifications: the output matcher's
+// reason, if any, and an excerpt of the output.
+func pushMessage(result RunResult) string {
+	excerpt := result.excerpt(maxPushExcerptLines, maxPushExcerptLength)
+	if excerpt == "" {
+		excerpt = "(no output)"
+	}
+	if result.OutputFailure != "" {
+		return result.OutputFailure + "\n\n" + excerpt
+	}
+
+	return excerpt
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNtfyNotifier_Notify(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newNtfyNotifier(NtfyConfig{
+		URL: srv.URL + "/", Topic: "jobs", Token: "tk_abc", PrioritySuccess: 3, PriorityFailure: 5,
+	})
+	require.NoError(t, err)
+
+	result := RunResult{ContainerName: "backup", ReturnCode: 1, Duration: time.Second, StdErr: "boom\n"}
+	require.NoError(t, n.Notify(context.Background(), result))
+
+	req := <-requests
+	require.Equal(t, "Bearer tk_abc", req.header.Get("Authorization"))
+
+	var msg ntfyMessage
+	require.NoError(t, json.Unmarshal(req.body, &msg))
+	require.Equal(t, "jobs", msg.Topic)
+	require.Equal(t, createTopic(result), msg.Title)
+	require.Equal(t, "boom", msg.Message)
+	require.Equal(t, 5, msg.Priority)
+	require.Equal(t, []string{"crony", "failure"}, msg.Tags)
+}
+
+func TestNtfyNotifier_BasicAuthAndSuccessPriority(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newNtfyNotifier(NtfyConfig{
+		URL: srv.URL, Topic: "jobs", User: "u", Password: "p", PrioritySuccess: 2, PriorityFailure: 4,
+	})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+
+	req := <-requests
+	require.Equal(t, "Basic dTpw", req.header.Get("Authorization"))
+
+	var msg ntfyMessage
+	require.NoError(t, json.Unmarshal(req.body, &msg))
+	require.Equal(t, 2, msg.Priority)
+	require.Equal(t, "(no output)", msg.Message)
+}
+
+func TestNtfyNotifier_InvalidConfig(t *testing.T) {
+	_, err := newNtfyNotifier(NtfyConfig{URL: "http://localhost"})
+	require.Error(t, err)
+
+	_, err = newNtfyNotifier(NtfyConfig{URL: "http://localhost", Topic: "t", PrioritySuccess: 3, PriorityFailure: 6})
+	require.ErrorContains(t, err, "priority")
+
+	_, err = newNtfyNotifier(NtfyConfig{
+		URL: "http://localhost", Topic: "t", Token: "x", User: "u", PrioritySuccess: 3, PriorityFailure: 4,
+	})
+	require.Error(t, err)
+}
+
+func TestNtfyConfig_Notification(t *testing.T) {
+	global := NtfyConfig{URL: "http://localhost", PrioritySuccess: 3, PriorityFailure: 4, Policy: OnError}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no topic configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{
+		ntfyTopicLabel:  "backups",
+		ntfyPolicyLabel: "always",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, Always, n.policy)
+	require.Equal(t, "ntfy", n.notifier.Name())
+
+	_, err = global.notification(CronyContainer{Labels: map[string]string{
+		ntfyTopicLabel:  "backups",
+		ntfyPolicyLabel: "sometimes",
+	}})
+	require.ErrorContains(t, err, ntfyPolicyLabel)
+}
+
+func TestPushMessage(t *testing.T) {
+	require.Equal(t, "output matched: ERR\n\nERR x",
+		pushMessage(RunResult{OutputFailure: "output matched: ERR", StdOut: "ERR x\n"}))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxMatchedLineLength = 200
+
+// OutputMatcher decides whether the captured output of a job turns an
+// otherwise successful run into a failure, or a failed run into a success.
+type OutputMatcher struct {
+	failIf        *regexp.Regexp
+	succeedOnlyIf *regexp.Regexp
+}
+
+// NewOutputMatcher compiles the given expressions. Empty expressions are
+// ignored; nil is returned if both are empty.
+func NewOutputMatcher(failIf, succeedOnlyIf string) (*OutputMatcher, error) {
+	if failIf == "" && succeedOnlyIf == "" {
+		return nil, nil //nolint:nilnil // no matcher configured is not an error
+	}
+
+	var m OutputMatcher
+	var err error
+	if failIf != "" {
+		if m.failIf, err = regexp.Compile(failIf); err != nil {
+			return nil, fmt.Errorf("invalid '%s' expression: %w", failIfOutputMatchesLabel, err)
+		}
+	}
+	if succeedOnlyIf != "" {
+		if m.succeedOnlyIf, err = regexp.Compile(succeedOnlyIf); err != nil {
+			return nil, fmt.Errorf("invalid '%s' expression: %w", succeedOnlyIfOutputMatchesLabel, err)
+		}
+	}
+
+	return &m, nil
+}
+
+// Check evaluates the matchers line by line against stdout and stderr. It
+// returns a non-empty reason if the output indicates a failure.
+func (m *OutputMatcher) Check(stdout, stderr string) string {
+	if m == nil {
+		return ""
+	}
+
+	if line, ok := firstMatch(m.failIf, stdout, stderr); ok {
+		return "output matched: " + truncateLine(strings.TrimSpace(line))
+	}
+	if m.succeedOnlyIf != nil {
+		if _, ok := firstMatch(m.succeedOnlyIf, stdout, stderr); !ok {
+			return fmt.Sprintf("no output line matched '%s'", m.succeedOnlyIf)
+		}
+	}
+
+	return ""
+}
+
+// Override evaluates the output of a run with a non-zero exit code. It
+// returns a non-empty reason if a line matches the succeed expression and
+// none matches the fail expression, turning the run into a success.
+func (m *OutputMatcher) Override(stdout, stderr string) string {
+	if m == nil || m.succeedOnlyIf == nil {
+		return ""
+	}
+	if _, ok := firstMatch(m.failIf, stdout, stderr); ok {
+		return ""
+	}
+	if line, ok := firstMatch(m.succeedOnlyIf, stdout, stderr); ok {
+		return "output matched: " + truncateLine(strings.TrimSpace(line))
+	}
+
+	return ""
+}
+
+// firstMatch returns the first line of stdout and then stderr matching re.
+func firstMatch(re *regexp.Regexp, stdout, stderr string) (string, bool) {
+	if re == nil {
+		return "", false
+	}
+
+	for _, out := range []string{stdout, stderr} {
+		for line := range strings.Lines(out) {
+			line = strings.TrimRight(line, "\r\n")
+			if re.MatchString(line) {
+				return line, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func truncateLine(line string) string {
+	runes := []rune(line)
+	if len(runes) <= maxMatchedLineLength {
+		return line
+	}
+
+	return string(runes[:maxMatchedLineLength]) + "…"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOutputMatcher_NoneConfigured(t *testing.T) {
+	m, err := NewOutputMatcher("", "")
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.Empty(t, m.Check("ERROR", ""))
+}
+
+func TestNewOutputMatcher_InvalidExpression(t *testing.T) {
+	_, err := NewOutputMatcher("(", "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), failIfOutputMatchesLabel)
+
+	_, err = NewOutputMatcher("", "[")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), succeedOnlyIfOutputMatchesLabel)
+}
+
+func TestOutputMatcher_FailIf(t *testing.T) {
+	m, err := NewOutputMatcher("ERROR", "")
+	require.NoError(t, err)
+
+	require.Empty(t, m.Check("all good\n", "warning\n"))
+	require.Equal(t, "output matched: ERROR: disk full", m.Check("start\n  ERROR: disk full\nend\n", ""))
+	require.Equal(t, "output matched: ERROR on stderr", m.Check("", "ERROR on stderr"))
+}
+
+func TestOutputMatcher_SucceedOnlyIf(t *testing.T) {
+	m, err := NewOutputMatcher("", `^backup complete$`)
+	require.NoError(t, err)
+
+	require.Empty(t, m.Check("starting\nbackup complete\n", ""))
+	require.Contains(t, m.Check("starting\nbackup aborted\n", ""), "no output line matched")
+}
+
+func TestOutputMatcher_FailIfWinsOverSucceedOnlyIf(t *testing.T) {
+	m, err := NewOutputMatcher("ERROR", "complete")
+	require.NoError(t, err)
+
+	require.Equal(t, "output matched: ERROR", m.Check("complete\n", "ERROR\n"))
+}
+
+func TestOutputMatcher_TruncatesLongLines(t *testing.T) {
+	m, err := NewOutputMatcher("ERROR", "")
+	require.NoError(t, err)
+
+	reason := m.Check("ERROR "+strings.Repeat("x", 500), "")
+	require.Less(t, len([]rune(reason)), 250)
+	require.True(t, strings.HasSuffix(reason, "…"))
+}
+
+func TestOutputMatcher_Override(t *testing.T) {
+	m, err := NewOutputMatcher("ERROR", `^backup complete`)
+	require.NoError(t, err)
+
+	require.Equal(t, "output matched: backup complete, 3 warnings",
+		m.Override("starting\nbackup complete, 3 warnings\n", "warning\n"))
+	require.Empty(t, m.Override("starting\nbackup aborted\n", ""))
+	require.Empty(t, m.Override("backup complete\n", "ERROR\n"), "fail expression wins")
+
+	m, err = NewOutputMatcher("ERROR", "")
+	require.NoError(t, err)
+	require.Empty(t, m.Override("all good\n", ""), "no succeed expression")
+	require.Empty(t, (*OutputMatcher)(nil).Override("backup complete", ""))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// secretFileSuffix marks the variables holding the file to read a secret
+// from, e.g. SMTP_PASSWORD_FILE for SMTP_PASSWORD.
+const secretFileSuffix = "_FILE"
+
+// redacted replaces secrets in output.
+const redacted = "<redacted>"
+
+// lookupEnv returns the value of the variable, preferring the CRONY_ prefixed
+// variant like envconfig.
+func lookupEnv(name string) string {
+	if v := os.Getenv("CRONY_" + name); v != "" {
+		return v
+	}
+
+	return os.Getenv(name)
+}
+
+// processConfig populates spec from the environment like envconfig and
+// reads its secrets from files.
+func processConfig(spec any) error {
+	if err := envconfig.Process("crony", spec); err != nil {
+		return err
+	}
+
+	return readSecretFiles(spec)
+}
+
+// readSecretFiles sets the string fields of spec tagged with secret:"true"
+// to the content of the file named by the variable with the _FILE suffix.
+// Trailing line breaks are removed, as most editors add them.
+func readSecretFiles(spec any) error {
+	v := reflect.ValueOf(spec).Elem()
+	t := v.Type()
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+
+		name := strings.ToUpper(field.Tag.Get("envconfig"))
+		file := lookupEnv(name + secretFileSuffix)
+		if file == "" {
+			continue
+		}
+		if lookupEnv(name) != "" {
+			return fmt.Errorf("%s and %s%s must not be set together", name, name, secretFileSuffix)
+		}
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("can't read %s%s: %w", name, secretFileSuffix, err)
+		}
+		v.Field(i).SetString(strings.TrimRight(string(b), "\r\n"))
+	}
+
+	return nil
+}
+
+// redactURL removes the password and the query values, which often hold
+// tokens, from rawURL.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			query.Set(k, "xxxxx")
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// urlSecrets returns the parts of rawURL which may hold secrets, the password
+// and the query, to be passed to redactError. Errors of http.Client contain
+// the request URL.
+func urlSecrets(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return []string{rawURL}
+	}
+
+	var secrets []string
+	if password, ok := u.User.Password(); ok {
+		secrets = append(secrets, password)
+	}
+	if u.RawQuery != "" {
+		secrets = append(secrets, "?"+u.RawQuery)
+	}
+
+	return secrets
+}
+
+// redactError replaces the secrets in the message of err.
+func redactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, redacted)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+
+	return errors.New(msg)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS modes of the SMTP connection. Without a mode, implicit TLS is used on
+// port 465 and STARTTLS if the server offers it.
+const (
+	smtpTLSModeNone     = "none"
+	smtpTLSModeStartTLS = "starttls"
+	smtpTLSModeTLS      = "tls"
+)
+
+// smtpSender sends mails via SMTP and keeps up to poolSize idle connections
+// for reuse.
+type smtpSender struct {
+	host string
+	port int
+	user string
+	// authMechanism is one of the smtpAuth* constants or empty
+	authMechanism string
+	tlsMode       string
+	tlsConfig     *tls.Config
+	dialTimeout   time.Duration
+	sendTimeout   time.Duration
+	poolSize      int
+	idleTimeout   time.Duration
+
+	// mu guards the idle connections and the secrets, which can be reloaded
+	mu       sync.Mutex
+	idle     []*smtpConn
+	password string
+	tokens   *oauthTokenSource
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func newSMTPSender(config *MailConfig) (*smtpSender, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.SmtpHost,
+		InsecureSkipVerify: config.SmtpInsecureSkipVerify, //nolint:gosec // explicitly requested by the user
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.SmtpCAFile != "" {
+		pem, err := os.ReadFile(config.SmtpCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read SMTP CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in SMTP CA file '%s'", config.SmtpCAFile)
+		}
+	}
+
+	var tokens *oauthTokenSource
+	if strings.EqualFold(config.SmtpAuthMechanism, smtpAuthXOAuth2) {
+		tokens = newOAuthTokenSource(config)
+	}
+
+	return &smtpSender{
+		host:          config.SmtpHost,
+		port:          config.SmtpPort,
+		user:          config.SmtpUser,
+		password:      config.SmtpPassword,
+		authMechanism: strings.ToLower(config.SmtpAuthMechanism),
+		tokens:        tokens,
+		tlsMode:       strings.ToLower(config.SmtpTLSMode),
+		tlsConfig:     tlsConfig,
+		dialTimeout:   config.SmtpDialTimeout,
+		sendTimeout:   config.SmtpSendTimeout,
+		poolSize:      config.SmtpPoolSize,
+		idleTimeout:   config.SmtpIdleTimeout,
+	}, nil
+}
+
+// Send delivers msg to the recipients. It gives up once ctx is done or the
+// send timeout is exceeded.
+func (s *smtpSender) Send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	stop := s.watch(ctx, c.conn)
+	err = s.send(c.client, from, to, msg)
+	interrupted := !stop()
+	if err != nil {
+		_ = c.conn.Close()
+		if ctxErr := contextExpired(ctx); interrupted || ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+
+		return err
+	}
+
+	if interrupted {
+		// sent, but the deadline of the connection is already in the past
+		_ = c.conn.Close()
+
+		return nil
+	}
+	s.release(c)
+
+	return nil
+}
+
+func (s *smtpSender) send(client *smtp.Client, from string, to []string, msg io.WriterTo) error {
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO '%s' failed: %w", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		return fmt.Errorf("can't write mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the mail: %w", err)
+	}
+
+	return nil
+}
+
+// watch sets the deadline of conn and interrupts it when ctx is done. The
+// returned function stops watching and reports false if ctx interrupted
+// the connection.
+func (s *smtpSender) watch(ctx context.Context, conn net.Conn) func() bool {
+	deadline := time.Now().Add(s.sendTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	return context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+}
+
+// contextExpired returns the error of ctx, also if its deadline is exceeded
+// but ctx isn't done yet, as the deadline of the connection may expire first.
+func contextExpired(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+// conn returns an idle connection which is still alive or dials a new one.
+func (s *smtpSender) conn(ctx context.Context) (*smtpConn, error) {
+	for {
+		c := s.takeIdle()
+		if c == nil {
+			break
+		}
+
+		_ = c.conn.SetDeadline(time.Now().Add(s.dialTimeout))
+		if err := c.client.Noop(); err == nil {
+			return c, nil
+		}
+		_ = c.conn.Close()
+	}
+
+	return s.dial(ctx)
+}
+
+func (s *smtpSender) takeIdle() *smtpConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.idle) > 0 {
+		c := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		if time.Since(c.lastUsed) < s.idleTimeout {
+			return c
+		}
+		_ = c.client.Close()
+	}
+
+	return nil
+}
+
+// release returns the connection to the pool or closes it, if the pool is
+// full.
+func (s *smtpSender) release(c *smtpConn) {
+	c.lastUsed = time.Now()
+	_ = c.conn.SetDeadline(time.Time{})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) < s.poolSize {
+		s.idle = append(s.idle, c)
+
+		return
+	}
+
+	_ = c.conn.SetDeadline(time.Now().Add(s.dialTimeout))
+	_ = c.client.Quit()
+}
+
+// Check connects to the SMTP server, including the TLS handshake and the
+// authentication, and keeps the connection for the first mail.
+func (s *smtpSender) Check(ctx context.Context) error {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	s.release(c)
+
+	return nil
+}
+
+// setCredentials replaces the secrets, e.g. after they were reloaded, and
+// closes the idle connections authenticated with the previous ones.
+func (s *smtpSender) setCredentials(config *MailConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.password = config.SmtpPassword
+	if s.tokens != nil {
+		s.tokens.setSecrets(config.SmtpOAuthClientSecret, config.SmtpOAuthRefreshToken)
+	}
+	s.closeIdle()
+}
+
+// Close closes all idle connections.
+func (s *smtpSender) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeIdle()
+}
+
+func (s *smtpSender) closeIdle() {
+	for _, c := range s.idle {
+		_ = c.conn.SetDeadline(time.Now().Add(s.dialTimeout))
+		_ = c.client.Quit()
+	}
+	s.idle = nil
+}
+
+func (s *smtpSender) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	dialer := &net.Dialer{Timeout: s.dialTimeout}
+
+	implicitTLS := s.tlsMode == smtpTLSModeTLS || (s.tlsMode == "" && s.port == 465)
+
+	var conn net.Conn
+	var err error
+	if implicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to SMTP server: %w", err)
+	}
+
+	// the handshake is bounded by the dial timeout as well
+	_ = conn.SetDeadline(time.Now().Add(s.dialTimeout))
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("SMTP handshake failed: %w", err)
+	}
+
+	if err := s.handshake(ctx, client, implicitTLS); err != nil {
+		_ = conn.Close()
+
+		return nil, err
+	}
+
+	return &smtpConn{conn: conn, client: client}, nil
+}
+
+func (s *smtpSender) handshake(ctx context.Context, client *smtp.Client, implicitTLS bool) error {
+	encrypted := implicitTLS
+	if !implicitTLS && s.tlsMode != smtpTLSModeNone {
+		ok, _ := client.Extension("STARTTLS")
+		if !ok && s.tlsMode == smtpTLSModeStartTLS {
+			return errors.New("SMTP server doesn't support STARTTLS")
+		}
+		if ok {
+			if err := client.StartTLS(s.tlsConfig); err != nil {
+				return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+			}
+			encrypted = true
+		}
+	}
+
+	if s.user != "" {
+		// without STARTTLS, the credentials would be sent in cleartext, e.g. if
+		// an attacker strips STARTTLS from the server's extensions
+		if !encrypted && s.tlsMode != smtpTLSModeNone {
+			return errors.New("SMTP server doesn't support STARTTLS, refusing to authenticate without encryption " +
+				"(set SMTP_TLS_MODE=none to allow it)")
+		}
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server doesn't support authentication")
+		}
+		s.mu.Lock()
+		password, tokens := s.password, s.tokens
+		s.mu.Unlock()
+
+		auth, err := s.auth(ctx, client, password, tokens)
+		if err != nil {
+			return err
+		}
+		if err := client.Auth(auth); err != nil {
+			if tokens != nil {
+				// the token may have been revoked, fetch a new one next time
+				tokens.invalidate()
+			}
+
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer is a minimal SMTP server recording the received mails.
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	// implicitTLS wraps connections in TLS before the greeting
+	implicitTLS bool
+	startTLS    bool
+	auth        string
+	// rejectAuth makes the server reject authentications containing it
+	rejectAuth string
+	// stall makes the server stop responding after DATA
+	stall bool
+
+	connections atomic.Int32
+
+	mu    sync.Mutex
+	mails []fakeMail
+}
+
+type fakeMail struct {
+	from string
+	to   []string
+	data string
+	auth string
+}
+
+func newFakeSMTPServer(t *testing.T, configure func(s *fakeSMTPServer)) *fakeSMTPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeSMTPServer{listener: l}
+	if configure != nil {
+		configure(s)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.connections.Add(1)
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) received() []fakeMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]fakeMail(nil), s.mails...)
+}
+
+//nolint:cyclop,funlen // a small protocol state machine
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	var mail fakeMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			reply("250-fake")
+			if s.startTLS {
+				reply("250-STARTTLS")
+			}
+			if s.auth != "" {
+				reply("250-AUTH " + s.auth)
+			}
+			reply("250 8BITMIME")
+		case "STARTTLS":
+			reply("220 go ahead")
+			conn = tls.Server(conn, s.tlsConfig)
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			mail.auth = strings.TrimPrefix(cmd, "AUTH ")
+			if mail.auth == "LOGIN" {
+				for _, prompt := range []string{"Username:", "Password:"} {
+					reply("334 " + base64.StdEncoding.EncodeToString([]byte(prompt)))
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					b, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(l))
+					mail.auth += " " + string(b)
+				}
+			}
+			if s.rejectAuth != "" && strings.Contains(mail.auth, s.rejectAuth) {
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(`{"status":"401"}`)))
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				reply("535 authentication failed")
+
+				continue
+			}
+			reply("235 authenticated")
+		case "MAIL":
+			mail.from = strings.TrimSuffix(strings.TrimPrefix(cmd, "MAIL FROM:<"), ">")
+			if i := strings.Index(mail.from, ">"); i >= 0 {
+				mail.from = mail.from[:i]
+			}
+			reply("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.TrimSuffix(strings.TrimPrefix(cmd, "RCPT TO:<"), ">"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			if s.stall {
+				time.Sleep(time.Second)
+
+				return
+			}
+			mail.data = data.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			mail = fakeMail{auth: mail.auth}
+			reply("250 queued")
+		case "NOOP", "RSET":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and its PEM encoding.
+func selfSignedCert(t *testing.T) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func testMailConfig(port int) *MailConfig {
+	return &MailConfig{
+		SmtpHost:        "127.0.0.1",
+		SmtpPort:        port,
+		MailFrom:        "Crony <crony@example.com>",
+		MailTo:          "a@example.com",
+		MailCc:          "B <b@example.com>",
+		MailBcc:         "c@example.com",
+		SmtpDialTimeout: time.Second,
+		SmtpSendTimeout: time.Second,
+		SmtpPoolSize:    2,
+		SmtpIdleTimeout: time.Minute,
+	}
+}
+
+func sendTestMail(t *testing.T, cfg *MailConfig) error {
+	t.Helper()
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+	t.Cleanup(sender.Close)
+
+	return sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>")
+}
+
+func TestSMTPSender_Plain(t *testing.T) {
+	srv := newFakeSMTPServer(t, nil)
+	cfg := testMailConfig(srv.port())
+
+	require.NoError(t, sendTestMail(t, cfg))
+
+	mails := srv.received()
+	require.Len(t, mails, 1)
+	require.Equal(t, "crony@example.com", mails[0].from)
+	require.Equal(t, []string{"a@example.com", "b@example.com", "c@example.com"}, mails[0].to)
+	require.Contains(t, mails[0].data, "Subject: subject")
+	require.NotContains(t, mails[0].data, "c@example.com")
+}
+
+func TestSMTPSender_ReusesConnections(t *testing.T) {
+	srv := newFakeSMTPServer(t, nil)
+	cfg := testMailConfig(srv.port())
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+	defer sender.Close()
+
+	for range 3 {
+		require.NoError(t, sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>"))
+	}
+
+	require.Len(t, srv.received(), 3)
+	require.Equal(t, int32(1), srv.connections.Load())
+}
+
+func TestSMTPSender_PoolDisabled(t *testing.T) {
+	srv := newFakeSMTPServer(t, nil)
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpPoolSize = 0
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+
+	for range 2 {
+		require.NoError(t, sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>"))
+	}
+	require.Equal(t, int32(2), srv.connections.Load())
+}
+
+func TestSMTPSender_StartTLS(t *testing.T) {
+	cert, caPEM := selfSignedCert(t)
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.startTLS = true
+		s.auth = "PLAIN LOGIN"
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpTLSMode = "starttls"
+	cfg.SmtpCAFile = caFile
+	cfg.SmtpUser = "user"
+	cfg.SmtpPassword = "secret"
+
+	require.NoError(t, sendTestMail(t, cfg))
+
+	mails := srv.received()
+	require.Len(t, mails, 1)
+	require.Equal(t, "PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret")), mails[0].auth)
+}
+
+func TestSMTPSender_StartTLSRequired(t *testing.T) {
+	srv := newFakeSMTPServer(t, nil)
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpTLSMode = "starttls"
+
+	require.ErrorContains(t, sendTestMail(t, cfg), "STARTTLS")
+}
+
+func TestSMTPSender_CertificateVerification(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.implicitTLS = true
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	})
+
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpTLSMode = "tls"
+	require.ErrorContains(t, sendTestMail(t, cfg), "certificate")
+
+	cfg.SmtpInsecureSkipVerify = true
+	require.NoError(t, sendTestMail(t, cfg))
+	require.Len(t, srv.received(), 1)
+}
+
+func TestSMTPSender_SendTimeout(t *testing.T) {
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) { s.stall = true })
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpSendTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	require.Error(t, sendTestMail(t, cfg))
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestSMTPSender_ContextCancel(t *testing.T) {
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) { s.stall = true })
+	cfg := testMailConfig(srv.port())
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = sendHTMLMail(ctx, sender, cfg, "subject", "<p>body</p>")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSMTPSender_RefusesUnencryptedAuth(t *testing.T) {
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) { s.auth = "PLAIN LOGIN" })
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpUser = "user"
+	cfg.SmtpPassword = "secret"
+
+	for _, mechanism := range []string{"", "plain", "login"} {
+		cfg.SmtpAuthMechanism = mechanism
+		require.ErrorContains(t, sendTestMail(t, cfg), "refusing to authenticate without encryption", mechanism)
+	}
+	require.Empty(t, srv.received())
+
+	cfg.SmtpTLSMode = "none"
+	require.NoError(t, sendTestMail(t, cfg), "explicitly allowed")
+	require.Len(t, srv.received(), 1)
+}
+
+func TestNewSMTPSender_InvalidCAFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("no certificate"), 0o600))
+
+	_, err := newSMTPSender(&MailConfig{SmtpCAFile: caFile})
+	require.ErrorContains(t, err, "no certificates")
+
+	_, err = newSMTPSender(&MailConfig{SmtpCAFile: caFile + ".missing"})
+	require.Error(t, err)
+}
+
+func TestMailConfig_ValidateTLSMode(t *testing.T) {
+	for _, mode := range []string{"", "none", "STARTTLS", "tls"} {
+		require.NoError(t, (&MailConfig{SmtpTLSMode: mode}).Validate(), mode)
+	}
+	require.ErrorContains(t, (&MailConfig{SmtpTLSMode: "ssl"}).Validate(), "SMTP_TLS_MODE")
+	require.Error(t, (&MailConfig{SmtpPoolSize: -1}).Validate())
+}
+
+func TestSMTPSender_Check(t *testing.T) {
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) { s.auth = "PLAIN" })
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpTLSMode = "none"
+	cfg.SmtpUser = "user"
+	cfg.SmtpPassword = "secret"
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+	defer sender.Close()
+
+	require.NoError(t, sender.Check(context.Background()))
+	require.NoError(t, sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>"))
+	require.Equal(t, int32(1), srv.connections.Load(), "the checked connection is reused")
+
+	cfg.SmtpPort = 1
+	sender, err = newSMTPSender(cfg)
+	require.NoError(t, err)
+	require.ErrorContains(t, sender.Check(context.Background()), "can't connect")
+}
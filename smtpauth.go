@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP authentication mechanisms. Without a mechanism, CRAM-MD5 is used if the
+// server offers it and PLAIN otherwise.
+const (
+	smtpAuthPlain   = "plain"
+	smtpAuthLogin   = "login"
+	smtpAuthCRAMMD5 = "cram-md5"
+	smtpAuthXOAuth2 = "xoauth2"
+)
+
+// tokenExpiryMargin renews OAuth tokens shortly before they expire, so they
+// don't expire during a delivery.
+const tokenExpiryMargin = time.Minute
+
+// auth returns the authentication for the configured mechanism.
+func (s *smtpSender) auth(ctx context.Context, client *smtp.Client,
+	password string, tokens *oauthTokenSource,
+) (smtp.Auth, error) {
+	switch s.authMechanism {
+	case smtpAuthPlain:
+		return &plainAuth{username: s.user, password: password}, nil
+	case smtpAuthLogin:
+		return &loginAuth{username: s.user, password: password}, nil
+	case smtpAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.user, password), nil
+	case smtpAuthXOAuth2:
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &xoauth2Auth{username: s.user, token: token}, nil
+	}
+
+	// like the previously used mail library
+	_, mechanisms := client.Extension("AUTH")
+	if slices.Contains(strings.Fields(mechanisms), "CRAM-MD5") {
+		return smtp.CRAMMD5Auth(s.user, password), nil
+	}
+
+	return &plainAuth{username: s.user, password: password}, nil
+}
+
+// plainAuth is smtp.PlainAuth without its own check of the connection, which
+// also refuses SMTP_TLS_MODE=none. Unencrypted connections are refused by
+// handshake unless SMTP_TLS_MODE=none is set.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+
+	return nil, nil
+}
+
+// loginAuth implements the LOGIN mechanism, which is still the only password
+// mechanism offered by some servers, e.g. Microsoft 365.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(challenge []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(challenge))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge '%s'", challenge)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism of Gmail and Microsoft 365.
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		// the challenge holds the error details, an empty response makes the
+		// server send the final error
+		return []byte{}, nil
+	}
+
+	return nil, nil
+}
+
+// oauthTokenSource fetches access tokens from an OAuth 2.0 token endpoint,
+// with the refresh token grant if a refresh token is configured and the
+// client credentials grant otherwise. Tokens are cached until shortly before
+// they expire.
+type oauthTokenSource struct {
+	url          string
+	clientID     string
+	clientSecret string
+	scopes       string
+	client       *http.Client
+	now          func() time.Time
+
+	mu           sync.Mutex
+	refreshToken string
+	token        string
+	expiry       time.Time
+
+	// configuredRefreshToken is the refresh token from the config, which may
+	// differ from refreshToken after the endpoint rotated it
+	configuredRefreshToken string
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func newOAuthTokenSource(config *MailConfig) *oauthTokenSource {
+	return &oauthTokenSource{
+		url:          config.SmtpOAuthTokenURL,
+		clientID:     config.SmtpOAuthClientID,
+		clientSecret: config.SmtpOAuthClientSecret,
+		scopes:       config.SmtpOAuthScopes,
+		refreshToken: config.SmtpOAuthRefreshToken,
+		client:       &http.Client{},
+		now:          time.Now,
+
+		configuredRefreshToken: config.SmtpOAuthRefreshToken,
+	}
+}
+
+// setSecrets updates the client secret and the refresh token after they were
+// reloaded. A refresh token rotated by the endpoint is kept unless the
+// configured one changed, and the cached access token stays valid.
+func (ts *oauthTokenSource) setSecrets(clientSecret, refreshToken string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.clientSecret = clientSecret
+	if refreshToken != ts.configuredRefreshToken {
+		ts.configuredRefreshToken = refreshToken
+		ts.refreshToken = refreshToken
+		ts.token = ""
+	}
+}
+
+// Token returns a cached access token or fetches a new one.
+func (ts *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && ts.now().Add(tokenExpiryMargin).Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	form := url.Values{"client_id": {ts.clientID}}
+	if ts.clientSecret != "" {
+		form.Set("client_secret", ts.clientSecret)
+	}
+	if ts.scopes != "" {
+		form.Set("scope", ts.scopes)
+	}
+	if ts.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", ts.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	resp, err := sendWithRetry(ctx, ts.client, 0, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.url, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't fetch OAuth token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("can't parse OAuth token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("OAuth token response contains no access token")
+	}
+
+	ts.token = token.AccessToken
+	ts.expiry = ts.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if token.RefreshToken != "" {
+		// the endpoint rotates the refresh token
+		ts.refreshToken = token.RefreshToken
+	}
+
+	return ts.token, nil
+}
+
+// invalidate drops the cached token, e.g. after the server rejected it.
+func (ts *oauthTokenSource) invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.token = ""
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer is a fake OAuth token endpoint issuing the tokens token-1,
+// token-2, ... and recording the received forms.
+type tokenServer struct {
+	*httptest.Server
+	issued atomic.Int32
+	forms  chan map[string]string
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	t.Helper()
+	ts := &tokenServer{forms: make(chan map[string]string, 10)}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form := map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		ts.forms <- form
+
+		n := ts.issued.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  fmt.Sprintf("token-%d", n),
+			"expires_in":    expiresIn,
+			"refresh_token": fmt.Sprintf("refresh-%d", n),
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func xoauth2Response(user, token string) string {
+	return "XOAUTH2 " + base64.StdEncoding.EncodeToString([]byte("user="+user+"\x01auth=Bearer "+token+"\x01\x01"))
+}
+
+func TestSMTPAuth_Mechanisms(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		expected  string
+	}{
+		{"", "CRAM-MD5"},
+		{"plain", "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret"))},
+		{"LOGIN", "LOGIN user secret"},
+		{"cram-md5", "CRAM-MD5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) { s.auth = "PLAIN LOGIN CRAM-MD5" })
+			cfg := testMailConfig(srv.port())
+			cfg.SmtpTLSMode = "none"
+			cfg.SmtpUser = "user"
+			cfg.SmtpPassword = "secret"
+			cfg.SmtpAuthMechanism = tt.mechanism
+
+			require.NoError(t, sendTestMail(t, cfg))
+			require.Len(t, srv.received(), 1)
+			require.Equal(t, tt.expected, srv.received()[0].auth)
+		})
+	}
+}
+
+func TestSMTPAuth_XOAuth2(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	srv := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.auth = "XOAUTH2"
+		s.rejectAuth = xoauth2Response("user@example.com", "token-1")[len("XOAUTH2 "):]
+	})
+
+	cfg := testMailConfig(srv.port())
+	cfg.SmtpTLSMode = "none"
+	cfg.SmtpPoolSize = 0
+	cfg.SmtpUser = "user@example.com"
+	cfg.SmtpAuthMechanism = "xoauth2"
+	cfg.SmtpOAuthTokenURL = tokens.URL
+	cfg.SmtpOAuthClientID = "client"
+	cfg.SmtpOAuthClientSecret = "client-secret"
+	cfg.SmtpOAuthRefreshToken = "refresh-0"
+	cfg.SmtpOAuthScopes = "https://outlook.office.com/SMTP.Send"
+	require.NoError(t, cfg.Validate())
+
+	sender, err := newSMTPSender(cfg)
+	require.NoError(t, err)
+
+	// the first token is rejected and dropped from the cache
+	err = sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>")
+	require.ErrorContains(t, err, "authentication failed")
+	require.Equal(t, map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": "refresh-0",
+		"client_id":     "client",
+		"client_secret": "client-secret",
+		"scope":         "https://outlook.office.com/SMTP.Send",
+	}, <-tokens.forms)
+
+	for range 2 {
+		require.NoError(t, sendHTMLMail(context.Background(), sender, cfg, "subject", "<p>body</p>"))
+	}
+	require.Equal(t, "refresh-1", (<-tokens.forms)["refresh_token"], "the rotated refresh token is used")
+	require.Equal(t, int32(2), tokens.issued.Load(), "the second token is cached")
+
+	mails := srv.received()
+	require.Len(t, mails, 2)
+	require.Equal(t, xoauth2Response("user@example.com", "token-2"), mails[0].auth)
+}
+
+func TestOAuthTokenSource_SetSecrets(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	ts := newOAuthTokenSource(&MailConfig{
+		SmtpOAuthTokenURL:     tokens.URL,
+		SmtpOAuthClientID:     "client",
+		SmtpOAuthClientSecret: "secret-1",
+		SmtpOAuthRefreshToken: "refresh-0",
+	})
+
+	token, err := ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+	<-tokens.forms
+
+	// an unchanged refresh token keeps the rotated one and the cached token
+	ts.setSecrets("secret-2", "refresh-0")
+	token, err = ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	ts.invalidate()
+	_, err = ts.Token(context.Background())
+	require.NoError(t, err)
+	form := <-tokens.forms
+	require.Equal(t, "secret-2", form["client_secret"])
+	require.Equal(t, "refresh-1", form["refresh_token"])
+
+	// a new refresh token replaces the rotated one
+	ts.setSecrets("secret-2", "refresh-new")
+	token, err = ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-3", token)
+	require.Equal(t, "refresh-new", (<-tokens.forms)["refresh_token"])
+}
+
+func TestOAuthTokenSource_ClientCredentials(t *testing.T) {
+	tokens := newTokenServer(t, 120)
+	ts := newOAuthTokenSource(&MailConfig{SmtpOAuthTokenURL: tokens.URL, SmtpOAuthClientID: "client"})
+	now := time.Now()
+	ts.now = func() time.Time { return now }
+
+	token, err := ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+	require.Equal(t, map[string]string{"grant_type": "client_credentials", "client_id": "client"}, <-tokens.forms)
+
+	now = now.Add(30 * time.Second)
+	token, err = ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	// renewed shortly before it expires
+	now = now.Add(45 * time.Second)
+	token, err = ts.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token)
+}
+
+func TestOAuthTokenSource_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			_, _ = w.Write([]byte(`{}`))
+
+			return
+		}
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := newOAuthTokenSource(&MailConfig{SmtpOAuthTokenURL: srv.URL}).Token(context.Background())
+	require.ErrorContains(t, err, "invalid_client")
+
+	_, err = newOAuthTokenSource(&MailConfig{SmtpOAuthTokenURL: srv.URL + "/empty"}).Token(context.Background())
+	require.ErrorContains(t, err, "no access token")
+}
+
+func TestMailConfig_ValidateAuth(t *testing.T) {
+	require.NoError(t, (&MailConfig{SmtpAuthMechanism: "LOGIN", SmtpUser: "u", SmtpPassword: "p"}).Validate())
+	require.ErrorContains(t, (&MailConfig{SmtpAuthMechanism: "ntlm", SmtpUser: "u", SmtpPassword: "p"}).Validate(),
+		"SMTP_AUTH_MECHANISM")
+	require.Error(t, (&MailConfig{SmtpAuthMechanism: "plain"}).Validate())
+
+	xoauth2 := MailConfig{SmtpAuthMechanism: "xoauth2", SmtpUser: "u"}
+	require.ErrorContains(t, xoauth2.Validate(), "SMTP_OAUTH_TOKEN_URL")
+	xoauth2.SmtpOAuthTokenURL = "https://login.example.com/token"
+	xoauth2.SmtpOAuthClientID = "client"
+	require.NoError(t, xoauth2.Validate(), "no password needed")
+}
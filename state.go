@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// jobState is the outcome of the last run of a job.
+type jobState struct {
+	Success bool `json:"success"`
+	// FailedRuns counts the consecutive failed runs up to the last run.
+	FailedRuns int       `json:"failed_runs"`
+	LastRun    time.Time `json:"last_run"`
+	// ReturnCode and ContainerID identify the alert of a failed run, see
+	// alertmanagerNotifier.
+	ReturnCode  int64  `json:"return_code,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+}
+
+// jobStates tracks the outcome of the previous run per job. If path is set,
+// the states are persisted, so they survive restarts.
+type jobStates struct {
+	mu     sync.Mutex
+	path   string
+	states map[string]jobState
+}
+
+// loadJobStates reads the states from path, if it exists. On error, the
+// returned jobStates is still usable, but starts without history.
+func loadJobStates(path string) (*jobStates, error) {
+	s := &jobStates{path: path, states: map[string]jobState{}}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("can't read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &s.states); err != nil {
+		s.states = map[string]jobState{}
+
+		return s, fmt.Errorf("can't parse state file '%s': %w", path, err)
+	}
+
+	return s, nil
+}
+
+// record stores the outcome of a run and returns the state before. Jobs
+// without history are considered successful.
+func (s *jobStates) record(job string, result RunResult) (jobState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.states[job]
+	if !ok {
+		previous = jobState{Success: true}
+	}
+
+	current := jobState{
+		Success:     result.Success(),
+		LastRun:     result.StartTime,
+		ReturnCode:  result.ReturnCode,
+		ContainerID: result.ContainerID,
+	}
+	if !current.Success {
+		current.FailedRuns = previous.FailedRuns + 1
+	}
+	s.states[job] = current
+
+	return previous, s.save()
+}
+
+func (s *jobStates) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("can't write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("can't write state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobStates_Record(t *testing.T) {
+	states, err := loadJobStates("")
+	require.NoError(t, err)
+
+	previous, err := states.record("job", RunResult{ReturnCode: 1})
+	require.NoError(t, err)
+	require.Equal(t, jobState{Success: true}, previous, "unknown jobs are considered successful")
+
+	previous, err = states.record("job", RunResult{ReturnCode: 1, ContainerID: "abc"})
+	require.NoError(t, err)
+	require.False(t, previous.Success)
+	require.Equal(t, 1, previous.FailedRuns)
+	require.Equal(t, int64(1), previous.ReturnCode)
+
+	previous, err = states.record("job", RunResult{})
+	require.NoError(t, err)
+	require.False(t, previous.Success)
+	require.Equal(t, 2, previous.FailedRuns)
+	require.Equal(t, "abc", previous.ContainerID)
+
+	previous, err = states.record("job", RunResult{})
+	require.NoError(t, err)
+	require.True(t, previous.Success)
+	require.Zero(t, previous.FailedRuns)
+
+	previous, err = states.record("other", RunResult{OutputFailure: "output matched"})
+	require.NoError(t, err)
+	require.True(t, previous.Success)
+}
+
+func TestJobStates_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	states, err := loadJobStates(path)
+	require.NoError(t, err)
+	_, err = states.record("job", RunResult{ReturnCode: 1})
+	require.NoError(t, err)
+
+	reloaded, err := loadJobStates(path)
+	require.NoError(t, err)
+	previous, err := reloaded.record("job", RunResult{})
+	require.NoError(t, err)
+	require.False(t, previous.Success)
+	require.Equal(t, 1, previous.FailedRuns)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files left")
+}
+
+func TestJobStates_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+	states, err := loadJobStates(path)
+	require.Error(t, err)
+	require.NotNil(t, states)
+
+	previous, err := states.record("job", RunResult{})
+	require.NoError(t, err, "the file is replaced")
+	require.True(t, previous.Success)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// telegramMaxMessageLength is the maximum length of a message text
+	telegramMaxMessageLength = 4096
+
+	telegramMarkdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+)
+
+// TelegramConfig configures notifications via the Telegram Bot API.
+type TelegramConfig struct {
+	BotToken string        `envconfig:"telegram_bot_token"     secret:"true"`
+	ChatID   string        `envconfig:"telegram_chat_id"`
+	ThreadID int64         `envconfig:"telegram_thread_id"`
+	APIURL   string        `default:"https://api.telegram.org" envconfig:"telegram_api_url"`
+	Retries  int           `default:"3"                        envconfig:"telegram_retries"`
+	Policy   MailPolicy    `default:"never"                    envconfig:"telegram_policy"`
+	Timeout  time.Duration `default:"30s"                      envconfig:"telegram_timeout"`
+}
+
+func (c TelegramConfig) String() string {
+	return fmt.Sprintf("telegram config [chatID=%s, threadID=%d, apiURL=%s, policy=%s]",
+		c.ChatID, c.ThreadID, redactURL(c.APIURL), c.Policy)
+}
+
+// forContainer applies the container's label overrides.
+func (c TelegramConfig) forContainer(container CronyContainer) (TelegramConfig, error) {
+	if v, ok := container.Labels[telegramChatIDLabel]; ok {
+		c.ChatID = v
+	}
+	if v, ok := container.Labels[telegramThreadIDLabel]; ok {
+		threadID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", telegramThreadIDLabel, err)
+		}
+		c.ThreadID = threadID
+	}
+	if v, ok := container.Labels[telegramPolicyLabel]; ok {
+		if err := c.Policy.Decode(v); err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", telegramPolicyLabel, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c TelegramConfig) notification(container CronyContainer) (*notification, error) {
+	cfg, err := c.forContainer(container)
+	if err != nil || cfg.BotToken == "" || cfg.ChatID == "" {
+		return nil, err
+	}
+
+	notifier, err := newTelegramNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification{notifier: notifier, policy: cfg.Policy, timeout: cfg.Timeout}, nil
+}
+
+type telegramMessage struct {
+	ChatID          string `json:"chat_id"`
+	MessageThreadID int64  `json:"message_thread_id,omitempty"`
+	Text            string `json:"text"`
+	ParseMode       string `json:"parse_mode"`
+}
+
+// telegramNotifier sends the result of a job run to a Telegram chat. If the
+// output doesn't fit into the message, it is attached as document.
+type telegramNotifier struct {
+	config TelegramConfig
+	client *http.Client
+}
+
+func newTelegramNotifier(config TelegramConfig) (*telegramNotifier, error) {
+	if config.BotToken == "" || config.ChatID == "" {
+		return nil, errors.New("telegram bot token and chat ID must be provided")
+	}
+	if config.APIURL == "" {
+		return nil, errors.New("telegram API URL is empty")
+	}
+
+	return &telegramNotifier{config: config, client: &http.Client{}}, nil
+}
+
+func (n *telegramNotifier) Name() string {
+	return "telegram"
+}
+
+func (n *telegramNotifier) Notify(ctx context.Context, result RunResult) error {
+	output := result.Output
+	if output == "" {
+		output = result.StdOut + result.StdErr
+	}
+
+	summary := "*" + escapeMarkdownV2(createTopic(result)) + "*"
+	text := summary
+	if output != "" {
+		text += "\n```\n" + escapeMarkdownV2Code(strings.TrimRight(output, "\n")) + "\n```"
+	}
+
+	attach := utf8.RuneCountInString(text) > telegramMaxMessageLength
+	if attach {
+		text = summary + "\n_" + escapeMarkdownV2("full output attached") + "_"
+	}
+
+	if err := n.sendMessage(ctx, text); err != nil {
+		return n.redact(err)
+	}
+	if attach {
+		return n.redact(n.sendDocument(ctx, fmt.Sprintf("%s-%s.txt", result.ContainerName, result.RunID), output))
+	}
+
+	return nil
+}
+
+func (n *telegramNotifier) sendMessage(ctx context.Context, text string) error {
+	body, err := json.Marshal(telegramMessage{
+		ChatID:          n.config.ChatID,
+		MessageThreadID: n.config.ThreadID,
+		Text:            text,
+		ParseMode:       "MarkdownV2",
+	})
+	if err != nil {
+		return err
+	}
+
+	return postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.methodURL("sendMessage"), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		return req, nil
+	})
+}
+
+func (n *telegramNotifier) sendDocument(ctx context.Context, fileName, content string) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	_ = w.WriteField("chat_id", n.config.ChatID)
+	if n.config.ThreadID != 0 {
+		_ = w.WriteField("message_thread_id", strconv.FormatInt(n.config.ThreadID, 10))
+	}
+	part, err := w.CreateFormFile("document", fileName)
+	if err != nil {
+		return err
+	}
+	_, _ = part.Write([]byte(content))
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.methodURL("sendDocument"),
+			bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+
+		return req, nil
+	})
+}
+
+func (n *telegramNotifier) methodURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(n.config.APIURL, "/"), n.config.BotToken, method)
+}
+
+// redact removes the bot token, which is part of the URL, from err.
+func (n *telegramNotifier) redact(err error) error {
+	return redactError(err, n.config.BotToken)
+}
+
+// escapeMarkdownV2 escapes all characters with special meaning in Telegram's
+// MarkdownV2.
+func escapeMarkdownV2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(telegramMarkdownV2Special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// escapeMarkdownV2Code escapes text inside of pre and code entities.
+func escapeMarkdownV2Code(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(s)
+}
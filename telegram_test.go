@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type telegramRequest struct {
+	path    string
+	message telegramMessage
+	form    map[string]string
+}
+
+func telegramServer(t *testing.T, status int) (*httptest.Server, chan telegramRequest) {
+	t.Helper()
+	requests := make(chan telegramRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := telegramRequest{path: r.URL.Path, form: map[string]string{}}
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				b, _ := io.ReadAll(part)
+				req.form[part.FormName()] = string(b)
+				if part.FileName() != "" {
+					req.form["filename"] = part.FileName()
+				}
+			}
+		} else {
+			_ = json.NewDecoder(r.Body).Decode(&req.message)
+		}
+		requests <- req
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, requests
+}
+
+func TestTelegramNotifier_ShortOutput(t *testing.T) {
+	srv, requests := telegramServer(t, http.StatusOK)
+
+	n, err := newTelegramNotifier(TelegramConfig{BotToken: "123:abc", ChatID: "-100", ThreadID: 7, APIURL: srv.URL})
+	require.NoError(t, err)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "my-job", ReturnCode: 1, StdErr: "a`b\n"}))
+
+	req := <-requests
+	require.Equal(t, "/bot123:abc/sendMessage", req.path)
+	require.Equal(t, "-100", req.message.ChatID)
+	require.Equal(t, int64(7), req.message.MessageThreadID)
+	require.Equal(t, "MarkdownV2", req.message.ParseMode)
+	require.Equal(t, "*\\[FAIL\\] ❌ 'my\\-job' failed in 0 seconds*\n```\na\\`b\n```", req.message.Text)
+	require.Empty(t, requests, "no document expected")
+}
+
+func TestTelegramNotifier_LongOutputIsAttached(t *testing.T) {
+	srv, requests := telegramServer(t, http.StatusOK)
+
+	n, err := newTelegramNotifier(TelegramConfig{BotToken: "123:abc", ChatID: "42", APIURL: srv.URL + "/"})
+	require.NoError(t, err)
+
+	output := strings.Repeat("line\n", 1000)
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "job", RunID: "r1", Output: output}))
+
+	msg := <-requests
+	require.Equal(t, "/bot123:abc/sendMessage", msg.path)
+	require.Contains(t, msg.message.Text, "full output attached")
+	require.NotContains(t, msg.message.Text, "line")
+
+	doc := <-requests
+	require.Equal(t, "/bot123:abc/sendDocument", doc.path)
+	require.Equal(t, "42", doc.form["chat_id"])
+	require.Equal(t, "job-r1.txt", doc.form["filename"])
+	require.Equal(t, output, doc.form["document"])
+}
+
+func TestTelegramNotifier_RedactsToken(t *testing.T) {
+	n, err := newTelegramNotifier(TelegramConfig{BotToken: "123:secret", ChatID: "42", APIURL: "http://127.0.0.1:1"})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), RunResult{})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret")
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	require.Equal(t, `a\_b\*c\.d\!e\\f`, escapeMarkdownV2(`a_b*c.d!e\f`))
+	require.Equal(t, "a\\\\b\\`c_d", escapeMarkdownV2Code("a\\b`c_d"))
+}
+
+func TestTelegramConfig_Notification(t *testing.T) {
+	global := TelegramConfig{BotToken: "t", APIURL: "http://localhost", Policy: OnError}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no chat ID configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{
+		telegramChatIDLabel:   "42",
+		telegramThreadIDLabel: "3",
+		telegramPolicyLabel:   "always",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, Always, n.policy)
+	notifier, ok := n.notifier.(*telegramNotifier)
+	require.True(t, ok)
+	require.Equal(t, int64(3), notifier.config.ThreadID)
+
+	_, err = global.notification(CronyContainer{Labels: map[string]string{
+		telegramChatIDLabel:   "42",
+		telegramThreadIDLabel: "x",
+	}})
+	require.ErrorContains(t, err, telegramThreadIDLabel)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errThrottled is returned by notifiers which dropped a notification because
+// of rate limiting.
+var errThrottled = errors.New("notification throttled")
+
+// throttle limits the rate of notifications per job and globally.
+type throttle struct {
+	mu sync.Mutex
+	// jobInterval is the minimum time between two notifications of a job
+	jobInterval time.Duration
+	// globalLimit is the maximum number of notifications per globalWindow
+	globalLimit  int
+	globalWindow time.Duration
+
+	lastSent map[string]time.Time
+	sent     []time.Time
+	now      func() time.Time
+}
+
+// newThrottle returns nil if neither limit is enabled.
+func newThrottle(jobInterval time.Duration, globalLimit int, globalWindow time.Duration) *throttle {
+	if jobInterval <= 0 && globalLimit <= 0 {
+		return nil
+	}
+
+	return &throttle{
+		jobInterval:  jobInterval,
+		globalLimit:  globalLimit,
+		globalWindow: globalWindow,
+		lastSent:     map[string]time.Time{},
+		now:          time.Now,
+	}
+}
+
+// allow reports whether a notification for job may be sent now and, if so,
+// counts it against the limits.
+func (t *throttle) allow(job string) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	if t.jobInterval > 0 {
+		if last, ok := t.lastSent[job]; ok && now.Sub(last) < t.jobInterval {
+			return false
+		}
+	}
+
+	if t.globalLimit > 0 {
+		i := 0
+		for i < len(t.sent) && now.Sub(t.sent[i]) >= t.globalWindow {
+			i++
+		}
+		t.sent = t.sent[i:]
+		if len(t.sent) >= t.globalLimit {
+			return false
+		}
+		t.sent = append(t.sent, now)
+	}
+
+	t.lastSent[job] = now
+
+	return true
+}
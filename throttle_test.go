@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewThrottle_Disabled(t *testing.T) {
+	thr := newThrottle(0, 0, time.Hour)
+	require.Nil(t, thr)
+	require.True(t, thr.allow("job"), "nil throttle allows everything")
+}
+
+func TestThrottle_JobInterval(t *testing.T) {
+	now := time.Now()
+	thr := newThrottle(time.Hour, 0, 0)
+	thr.now = func() time.Time { return now }
+
+	require.True(t, thr.allow("a"))
+	require.False(t, thr.allow("a"))
+	require.True(t, thr.allow("b"), "jobs are limited independently")
+
+	now = now.Add(59 * time.Minute)
+	require.False(t, thr.allow("a"))
+
+	now = now.Add(time.Minute)
+	require.True(t, thr.allow("a"))
+}
+
+func TestThrottle_GlobalLimit(t *testing.T) {
+	now := time.Now()
+	thr := newThrottle(0, 2, time.Hour)
+	thr.now = func() time.Time { return now }
+
+	require.True(t, thr.allow("a"))
+	now = now.Add(30 * time.Minute)
+	require.True(t, thr.allow("b"))
+	require.False(t, thr.allow("c"))
+
+	now = now.Add(30 * time.Minute)
+	require.True(t, thr.allow("c"), "the first notification left the window")
+	require.False(t, thr.allow("d"))
+}
+
+func TestThrottle_RejectedDoesNotCount(t *testing.T) {
+	now := time.Now()
+	thr := newThrottle(time.Hour, 1, time.Minute)
+	thr.now = func() time.Time { return now }
+
+	require.True(t, thr.allow("a"))
+	require.False(t, thr.allow("b"), "global limit")
+
+	now = now.Add(time.Minute)
+	require.True(t, thr.allow("b"), "b wasn't recorded while rejected")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-Crony-Signature"
+
+	defaultWebhookBodyTemplate = `{` +
+		`"container":{{json .ContainerName}},` +
+		`"container_id":{{json .ContainerID}},` +
+		`"run_id":{{json .RunID}},` +
+		`"success":{{.Success}},` +
+		`"outcome":{{json .Outcome}},` +
+		`"return_code":{{.ReturnCode}},` +
+		`"output_failure":{{json .OutputFailure}},` +
+		`"start_time":{{json .StartTime}},` +
+		`"end_time":{{json .EndTime}},` +
+		`"duration":{{json .ShortDuration}},` +
+		`"duration_ms":{{.Duration.Milliseconds}},` +
+		`"stdout":{{json .StdOut}},` +
+		`"stderr":{{json .StdErr}}` +
+		`}`
+)
+
+type WebhookConfig struct {
+	URL          string        `envconfig:"webhook_url"`
+	Method       string        `default:"POST"              envconfig:"webhook_method"`
+	Headers      string        `envconfig:"webhook_headers" secret:"true"`
+	BodyTemplate string        `envconfig:"webhook_body_template"`
+	Secret       string        `envconfig:"webhook_secret"  secret:"true"`
+	Retries      int           `default:"3"                 envconfig:"webhook_retries"`
+	Policy       MailPolicy    `default:"never"             envconfig:"webhook_policy"`
+	Timeout      time.Duration `default:"30s"               envconfig:"webhook_timeout"`
+}
+
+func (c WebhookConfig) String() string {
+	return fmt.Sprintf("webhook config [url=%s, method=%s, policy=%s]", redactURL(c.URL), c.Method, c.Policy)
+}
+
+// forContainer applies the container's label overrides.
+func (c WebhookConfig) forContainer(container CronyContainer) (WebhookConfig, error) {
+	overrides := map[string]*string{
+		webhookURLLabel:          &c.URL,
+		webhookMethodLabel:       &c.Method,
+		webhookHeadersLabel:      &c.Headers,
+		webhookBodyTemplateLabel: &c.BodyTemplate,
+	}
+	for label, field := range overrides {
+		if v, ok := container.Labels[label]; ok {
+			*field = v
+		}
+	}
+
+	if v, ok := container.Labels[webhookPolicyLabel]; ok {
+		if err := c.Policy.Decode(v); err != nil {
+			return c, fmt.Errorf("can't parse '%s' label: %w", webhookPolicyLabel, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c WebhookConfig) notification(container CronyContainer) (*notification, error) {
+	cfg, err := c.forContainer(container)
+	if err != nil || cfg.URL == "" {
+		return nil, err
+	}
+
+	notifier, err := newWebhookNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification{notifier: notifier, policy: cfg.Policy, timeout: cfg.Timeout}, nil
+}
+
+// webhookNotifier sends the result of a job run as templated HTTP request.
+type webhookNotifier struct {
+	config  WebhookConfig
+	headers http.Header
+	tmpl    *template.Template
+	client  *http.Client
+}
+
+func newWebhookNotifier(config WebhookConfig) (*webhookNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook URL is empty")
+	}
+
+	headers, err := parseHeaders(config.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	body := config.BodyTemplate
+	if body == "" {
+		body = defaultWebhookBodyTemplate
+	}
+	tmpl, err := template.New("webhook-body").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse webhook body template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, RunResult{}); err != nil {
+		return nil, fmt.Errorf("invalid webhook body template: %w", err)
+	}
+
+	return &webhookNotifier{
+		config:  config,
+		headers: headers,
+		tmpl:    tmpl,
+		client:  &http.Client{},
+	}, nil
+}
+
+func (n *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, result RunResult) error {
+	var body bytes.Buffer
+	if err := n.tmpl.Execute(&body, result); err != nil {
+		return fmt.Errorf("can't render webhook body: %w", err)
+	}
+
+	err := postWithRetry(ctx, n.client, n.config.Retries, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, strings.ToUpper(n.config.Method), n.config.URL,
+			bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.headers {
+			req.Header[k] = v
+		}
+		if n.config.Secret != "" {
+			req.Header.Set(webhookSignatureHeader, sign(n.config.Secret, body.Bytes()))
+		}
+
+		return req, nil
+	})
+
+	return redactError(err, urlSecrets(n.config.URL)...)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of body, prefixed with "sha256=".
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseHeaders parses a comma separated list of "Name: value" pairs.
+func parseHeaders(value string) (http.Header, error) {
+	headers := http.Header{}
+	for pair := range strings.SplitSeq(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header '%s', expected 'Name: value'", pair)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	}
+
+	return headers, nil
+}
+
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+
+	return string(b), err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordedWebhook struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, chan recordedWebhook, *atomic.Int32) {
+	t.Helper()
+	requests := make(chan recordedWebhook, 10)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- recordedWebhook{method: r.Method, header: r.Header.Clone(), body: body}
+		i := int(calls.Add(1)) - 1
+		if i < len(statuses) {
+			w.WriteHeader(statuses[i])
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, requests, &calls
+}
+
+func TestWebhookNotifier_DefaultBody(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newWebhookNotifier(WebhookConfig{URL: srv.URL, Method: "post"})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), RunResult{
+		ContainerName: "backup",
+		RunID:         "abc",
+		ReturnCode:    2,
+		Duration:      90 * time.Second,
+		StdOut:        "line \"1\"\n",
+		StdErr:        "boom",
+	})
+	require.NoError(t, err)
+
+	req := <-requests
+	require.Equal(t, http.MethodPost, req.method)
+	require.Equal(t, "application/json", req.header.Get("Content-Type"))
+	require.Empty(t, req.header.Get(webhookSignatureHeader))
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(req.body, &payload), string(req.body))
+	require.Equal(t, "backup", payload["container"])
+	require.Equal(t, "abc", payload["run_id"])
+	require.Equal(t, false, payload["success"])
+	require.Equal(t, "failure", payload["outcome"])
+	require.InDelta(t, 2, payload["return_code"], 0)
+	require.InDelta(t, 90000, payload["duration_ms"], 0)
+	require.Equal(t, "1 minute 30 seconds", payload["duration"])
+	require.Equal(t, "line \"1\"\n", payload["stdout"])
+	require.Equal(t, "boom", payload["stderr"])
+}
+
+func TestWebhookNotifier_CustomTemplateHeadersAndSignature(t *testing.T) {
+	srv, requests, _ := webhookServer(t)
+
+	n, err := newWebhookNotifier(WebhookConfig{
+		URL:          srv.URL,
+		Method:       "PUT",
+		Headers:      "Authorization: Bearer token, X-Source:crony",
+		BodyTemplate: `{"text":{{json (printf "%s: %s" .ContainerName .Outcome)}}}`,
+		Secret:       "s3cret",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{ContainerName: "backup"}))
+
+	req := <-requests
+	require.Equal(t, http.MethodPut, req.method)
+	require.JSONEq(t, `{"text":"backup: success"}`, string(req.body))
+	require.Equal(t, "Bearer token", req.header.Get("Authorization"))
+	require.Equal(t, "crony", req.header.Get("X-Source"))
+	require.Equal(t, sign("s3cret", req.body), req.header.Get(webhookSignatureHeader))
+}
+
+func TestSign(t *testing.T) {
+	require.Equal(t,
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		sign("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestWebhookNotifier_RetriesServerErrors(t *testing.T) {
+	srv, _, calls := webhookServer(t, http.StatusBadGateway, http.StatusOK)
+
+	n, err := newWebhookNotifier(WebhookConfig{URL: srv.URL, Method: "POST", Retries: 3})
+	require.NoError(t, err)
+
+	require.NoError(t, n.Notify(context.Background(), RunResult{}))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestWebhookNotifier_NoRetryOnClientError(t *testing.T) {
+	srv, _, calls := webhookServer(t, http.StatusBadRequest, http.StatusOK)
+
+	n, err := newWebhookNotifier(WebhookConfig{URL: srv.URL, Method: "POST", Retries: 3})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), RunResult{})
+	require.ErrorContains(t, err, "400")
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestWebhookNotifier_InvalidConfig(t *testing.T) {
+	_, err := newWebhookNotifier(WebhookConfig{})
+	require.Error(t, err)
+
+	_, err = newWebhookNotifier(WebhookConfig{URL: "http://localhost", BodyTemplate: "{{"})
+	require.ErrorContains(t, err, "template")
+
+	_, err = newWebhookNotifier(WebhookConfig{URL: "http://localhost", BodyTemplate: "{{.Unknown}}"})
+	require.ErrorContains(t, err, "template")
+
+	_, err = newWebhookNotifier(WebhookConfig{URL: "http://localhost", Headers: "no-colon"})
+	require.ErrorContains(t, err, "header")
+}
+
+func TestWebhookConfig_Notification(t *testing.T) {
+	global := WebhookConfig{Method: "POST", Policy: OnError, Timeout: time.Second}
+
+	n, err := global.notification(CronyContainer{})
+	require.NoError(t, err)
+	require.Nil(t, n, "no URL configured")
+
+	n, err = global.notification(CronyContainer{Labels: map[string]string{
+		webhookURLLabel:    "http://example.com/hook",
+		webhookPolicyLabel: "always",
+	}})
+	require.NoError(t, err)
+	require.NotNil(t, n)
+	require.Equal(t, Always, n.policy)
+	require.Equal(t, time.Second, n.timeout)
+	require.Equal(t, "webhook", n.notifier.Name())
+
+	_, err = global.notification(CronyContainer{Labels: map[string]string{
+		webhookURLLabel:    "http://example.com/hook",
+		webhookPolicyLabel: "sometimes",
+	}})
+	require.ErrorContains(t, err, webhookPolicyLabel)
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := parseHeaders("")
+	require.NoError(t, err)
+	require.Empty(t, h)
+
+	h, err = parseHeaders("X-A: 1, x-b:two:three")
+	require.NoError(t, err)
+	require.Equal(t, "1", h.Get("X-A"))
+	require.Equal(t, "two:three", h.Get("X-B"))
+}